@@ -2,32 +2,26 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 func main() {
 	sshHost := os.Getenv("SSH_HOST")
-	sshPass := os.Getenv("SSH_PASS")
 
-	if sshHost == "" || sshPass == "" {
-		log.Fatal("SSH_HOST and SSH_PASS must be set")
+	if sshHost == "" {
+		log.Fatal("SSH_HOST must be set")
 	}
 
-	user, host := parseUserHost(sshHost)
-
-	// SSH client config with password
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(sshPass)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	target, err := sshkit.Resolve(sshHost)
+	if err != nil {
+		log.Fatalf("Invalid SSH_HOST %s: %v", sshHost, err)
 	}
 
-	client, err := ssh.Dial("tcp", host+":22", config)
+	// SSH client: credentials and jump hosts from the environment
+	client, err := sshkit.DialerFromEnv().Connect(target)
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
@@ -37,44 +31,15 @@ func main() {
 	localFile := "remoteprog.go"
 	remoteFile := "/tmp/remoteprog.go"
 
-	sftpClient, err := sftp.NewClient(client)
-	if err != nil {
-		log.Fatalf("Failed to start SFTP: %v", err)
-	}
-	defer sftpClient.Close()
-
-	srcFile, err := os.Open(localFile)
-	if err != nil {
-		log.Fatalf("Failed to open local file: %v", err)
-	}
-	defer srcFile.Close()
-
-	dstFile, err := sftpClient.Create(remoteFile)
-	if err != nil {
-		log.Fatalf("Failed to create remote file: %v", err)
-	}
-	defer dstFile.Close()
-
-	data, err := ioutil.ReadAll(srcFile)
-	if err != nil {
-		log.Fatalf("Failed to read local file: %v", err)
-	}
-
-	if _, err := dstFile.Write(data); err != nil {
-		log.Fatalf("Failed to write remote file: %v", err)
+	if err := client.UploadFile(localFile, remoteFile, 0644); err != nil {
+		log.Fatalf("Failed to upload file: %v", err)
 	}
 
 	fmt.Println("File uploaded successfully")
 
 	// Run remote Go program
-	session, err := client.NewSession()
-	if err != nil {
-		log.Fatalf("Failed to create SSH session: %v", err)
-	}
-	defer session.Close()
-
-	cmd := fmt.Sprintf("go run %s", remoteFile)
-	output, err := session.CombinedOutput(cmd)
+	cmd := sshkit.NewCommand("go", "run", remoteFile).String()
+	output, err := client.Output(cmd)
 	if err != nil {
 		log.Fatalf("Failed to run remote program: %v\nOutput: %s", err, output)
 	}
@@ -82,17 +47,6 @@ func main() {
 	fmt.Printf("Remote program output:\n%s\n", output)
 }
 
-// parseUserHost splits "user@host" into user and host
-func parseUserHost(s string) (string, string) {
-	for i := 0; i < len(s); i++ {
-		if s[i] == '@' {
-			return s[:i], s[i+1:]
-		}
-	}
-	log.Fatalf("Invalid SSH_HOST format: %s", s)
-	return "", ""
-}
-
 /*
 ssh <SSH_HOST>
 sudo apt update
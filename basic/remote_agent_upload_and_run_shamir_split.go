@@ -2,24 +2,24 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
 
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 func main() {
 	sshHost := os.Getenv("SSH_HOST")
-	sshPass := os.Getenv("SSH_PASS")
 
-	if sshHost == "" || sshPass == "" {
-		log.Fatal("SSH_HOST and SSH_PASS must be set")
+	if sshHost == "" {
+		log.Fatal("SSH_HOST must be set")
 	}
 
-	user, host := parseUserHost(sshHost)
+	target, err := sshkit.Resolve(sshHost)
+	if err != nil {
+		log.Fatalf("Invalid SSH_HOST %s: %v", sshHost, err)
+	}
 
 	// Build the Go executable locally from remote_shamir.go
 	localExe := "remote_shamir_tool"
@@ -35,47 +35,22 @@ func main() {
 
 	fmt.Println("Executable built:", localExe)
 
-	// SSH client
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(sshPass)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := ssh.Dial("tcp", host+":22", config)
+	// SSH client: credentials and jump hosts from the environment
+	client, err := sshkit.DialerFromEnv().Connect(target)
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
 	defer client.Close()
 
-	// Start SFTP
-	sftpClient, err := sftp.NewClient(client)
-	if err != nil {
-		log.Fatalf("Failed to start SFTP: %v", err)
-	}
-	defer sftpClient.Close()
-
 	remoteFile := "/tmp/" + localExe
-	uploadFile(sftpClient, localExe, remoteFile)
-
-	// Make remote executable
-	session, err := client.NewSession()
-	if err != nil {
-		log.Fatalf("Failed to create SSH session: %v", err)
+	if err := client.UploadFile(localExe, remoteFile, 0755); err != nil {
+		log.Fatalf("Failed to upload executable: %v", err)
 	}
-	defer session.Close()
-
-	fmt.Println("Making remote file executable...")
-	session.Run(fmt.Sprintf("chmod +x %s", remoteFile))
+	fmt.Println("Uploaded executable to remote:", remoteFile)
 
 	// Run remote executable
-	session2, err := client.NewSession()
-	if err != nil {
-		log.Fatalf("Failed to create SSH session: %v", err)
-	}
-	defer session2.Close()
-
 	fmt.Println("Running remote executable...")
-	output, err := session2.CombinedOutput(remoteFile)
+	output, err := client.Output(sshkit.NewCommand(remoteFile).String())
 	if err != nil {
 		log.Fatalf("Failed to run remote executable: %v\nOutput: %s", err, output)
 	}
@@ -83,36 +58,6 @@ func main() {
 	fmt.Printf("Remote output:\n%s\n", output)
 }
 
-func uploadFile(sftpClient *sftp.Client, localPath, remotePath string) {
-	srcFile, err := os.Open(localPath)
-	if err != nil {
-		log.Fatalf("Failed to open local file: %v", err)
-	}
-	defer srcFile.Close()
-
-	dstFile, err := sftpClient.Create(remotePath)
-	if err != nil {
-		log.Fatalf("Failed to create remote file: %v", err)
-	}
-	defer dstFile.Close()
-
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		log.Fatalf("Failed to write remote file: %v", err)
-	}
-
-	fmt.Println("Uploaded executable to remote:", remotePath)
-}
-
-func parseUserHost(s string) (string, string) {
-	for i := 0; i < len(s); i++ {
-		if s[i] == '@' {
-			return s[:i], s[i+1:]
-		}
-	}
-	log.Fatalf("Invalid SSH_HOST format: %s", s)
-	return "", ""
-}
-
 /*
 ssh <SSH_HOST>
 sudo apt update
@@ -2,44 +2,38 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strings"
 
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 func main() {
 	sshHost := os.Getenv("SSH_HOST")
-	sshPass := os.Getenv("SSH_PASS")
 
-	if sshHost == "" || sshPass == "" {
-		log.Fatal("SSH_HOST and SSH_PASS must be set")
+	if sshHost == "" {
+		log.Fatal("SSH_HOST must be set")
 	}
 
-	user, host := parseUserHost(sshHost)
-
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(sshPass)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	target, err := sshkit.Resolve(sshHost)
+	if err != nil {
+		log.Fatalf("Invalid SSH_HOST %s: %v", sshHost, err)
 	}
 
-	client, err := ssh.Dial("tcp", host+":22", config)
+	// SSH client: credentials and jump hosts from the environment
+	client, err := sshkit.DialerFromEnv().Connect(target)
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
 	defer client.Close()
 
-	// Start SFTP
-	sftpClient, err := sftp.NewClient(client)
+	// Start SFTP (shared with client.Upload / client.Download)
+	sftpClient, err := client.SFTP()
 	if err != nil {
 		log.Fatalf("Failed to start SFTP: %v", err)
 	}
-	defer sftpClient.Close()
 
 	remoteDir := "/tmp/keys"
 	sftpClient.Mkdir(remoteDir) // ignore error if exists
@@ -50,12 +44,9 @@ func main() {
 		remoteFile := path.Join(remoteDir, filename)
 		content := fmt.Sprintf("{\"key\": \"%s\"}", filename)
 
-		f, err := sftpClient.Create(remoteFile)
-		if err != nil {
+		if err := client.Upload(strings.NewReader(content), remoteFile, 0644); err != nil {
 			log.Fatalf("Failed to create remote file %s: %v", filename, err)
 		}
-		f.Write([]byte(content))
-		f.Close()
 	}
 
 	fmt.Println("Created key_01.json ... key_05.json on remote server.")
@@ -77,35 +68,13 @@ func main() {
 	remotePath := path.Join(remoteDir, chosen)
 	localPath := path.Join(".", chosen)
 
-	remoteFile, err := sftpClient.Open(remotePath)
-	if err != nil {
-		log.Fatalf("Failed to open remote file: %v", err)
-	}
-	defer remoteFile.Close()
-
-	data, err := ioutil.ReadAll(remoteFile)
-	if err != nil {
-		log.Fatalf("Failed to read remote file: %v", err)
-	}
-
-	err = ioutil.WriteFile(localPath, data, 0644)
-	if err != nil {
-		log.Fatalf("Failed to write local file: %v", err)
+	if err := client.Download(remotePath, localPath); err != nil {
+		log.Fatalf("Failed to download remote file: %v", err)
 	}
 
 	fmt.Printf("Downloaded %s to local directory.\n", chosen)
 }
 
-func parseUserHost(s string) (string, string) {
-	for i := 0; i < len(s); i++ {
-		if s[i] == '@' {
-			return s[:i], s[i+1:]
-		}
-	}
-	log.Fatalf("Invalid SSH_HOST format: %s", s)
-	return "", ""
-}
-
 /*
 ssh <SSH_HOST>
 sudo apt update
@@ -9,17 +9,17 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
-	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 // --- Embed all payload binaries in payloads/ directory -----------------------
 // Drop any executable you want to carry in payloads/, e.g. openssl, mytool.
+//
 //go:embed payloads/*
 var payloads embed.FS
 
@@ -29,45 +29,67 @@ func main() {
 	passwordEnv := os.Getenv("SSH_PASS")
 	keyEnv := os.Getenv("SSH_KEY")
 
-	userEnv := ""
-	hostOnly := ""
-	if hostEnv != "" && strings.Contains(hostEnv, "@") {
-		parts := strings.SplitN(hostEnv, "@", 2)
-		userEnv = parts[0]
-		hostOnly = parts[1]
-	} else {
-		hostOnly = hostEnv
-	}
-
 	// Command-line flags
-	host := flag.String("host", hostOnly, "Remote host or IP")
-	port := flag.Int("port", 22, "SSH port")
-	user := flag.String("user", userEnv, "SSH username")
+	host := flag.String("host", hostEnv, "Remote [user@]host[:port] or ~/.ssh/config alias")
+	port := flag.Int("port", 0, "SSH port (default from ~/.ssh/config, else 22)")
+	user := flag.String("user", "", "SSH username (overrides SSH_HOST and ~/.ssh/config)")
+	jump := flag.String("jump", os.Getenv("SSH_JUMP"), "Comma-separated jump hosts [user@]host[:port] (overrides ProxyJump)")
 	keyPath := flag.String("key", keyEnv, "Path to private key (PEM)")
+	jumpKey := flag.String("jump-key", os.Getenv("SSH_JUMP_KEY"), "Path to private key for the jump hosts (-jump or ProxyJump), when it differs from -key")
 	password := flag.String("password", passwordEnv, "SSH password")
 	remoteTmp := flag.String("remote-tmp", "/tmp", "Remote temp dir to upload binaries")
 	installDir := flag.String("install-dir", "/usr/local/bin", "Remote install dir for binaries")
 	sudo := flag.Bool("sudo", true, "Use sudo to install binaries")
-	selfTest := flag.String("self-test", "--version", "Command line args for self-test")
+	sudoMethod := flag.String("sudo-method", "sudo", "How to become root: sudo, doas or su")
+	sudoPassword := flag.String("sudo-password", os.Getenv("SUDO_PASS"), "sudo password, sent only when prompted (default $SUDO_PASS, else the SSH password; empty for NOPASSWD)")
+	selfTest := flag.String("self-test", "--version", "Command line args for self-test, split on spaces and passed literally")
 	timeoutSec := flag.Int("timeout", 30, "SSH timeout in seconds")
 	flag.Parse()
 
-	if *host == "" || *user == "" {
-		log.Fatalf("host and user must be provided (via flags or SSH_HOST env)")
+	if *host == "" {
+		log.Fatalf("host must be provided (via -host or SSH_HOST env)")
 	}
 
-	addr := net.JoinHostPort(*host, fmt.Sprint(*port))
-	cfg, err := sshConfig(*user, *keyPath, *password)
+	method, err := sshkit.ParseSudoMethod(*sudoMethod)
 	if err != nil {
-		log.Fatalf("ssh config error: %v", err)
+		log.Fatal(err)
+	}
+	if *sudoPassword == "" {
+		*sudoPassword = *password
 	}
-	cfg.Timeout = time.Duration(*timeoutSec) * time.Second
 
-	client, err := ssh.Dial("tcp", addr, cfg)
+	target, err := sshkit.Resolve(*host)
+	if err != nil {
+		log.Fatalf("resolve %s: %v", *host, err)
+	}
+	if *user != "" {
+		target.User = *user
+	}
+	if *port != 0 {
+		target.Port = fmt.Sprint(*port)
+	}
+
+	dialer := sshkit.DialerFromEnv()
+	dialer.Auth.Password = *password
+	dialer.Auth.KeyFiles = nil
+	if *keyPath != "" {
+		dialer.Auth.KeyFiles = []string{*keyPath}
+	}
+	dialer.Timeout = time.Duration(*timeoutSec) * time.Second
+	dialer.Jump = *jump
+	dialer.JumpAuth = nil
+	if *jumpKey != "" {
+		dialer = dialer.WithJumpKeys([]string{*jumpKey})
+	}
+
+	client, err := dialer.Connect(target)
 	if err != nil {
 		log.Fatalf("ssh dial: %v", err)
 	}
 	defer client.Close()
+	client.UseSudo = *sudo
+	client.SudoMethod = method
+	client.SudoPassword = *sudoPassword
 
 	// Read all embedded payloads
 	entries, err := fs.ReadDir(payloads, "payloads")
@@ -82,9 +104,9 @@ func main() {
 		name := entry.Name()
 
 		// Skip hidden files like .DS_Store
-    	if strings.HasPrefix(name, ".") || entry.IsDir() {
-        	continue
-    	}
+		if strings.HasPrefix(name, ".") || entry.IsDir() {
+			continue
+		}
 
 		data, err := payloads.ReadFile("payloads/" + name)
 		if err != nil {
@@ -94,46 +116,18 @@ func main() {
 
 		remoteTmpPath := filepath.Join(*remoteTmp, name)
 		remoteInstallPath := filepath.Join(*installDir, name)
+		localSHA := fmt.Sprintf("%x", sha256.Sum256(data))
 
-		// Upload
-		if err := scpUpload(client, data, remoteTmpPath, 0o755); err != nil {
-			log.Fatalf("scp upload %s: %v", name, err)
-		}
-		log.Printf("uploaded %s to %s (%d bytes)", name, remoteTmpPath, len(data))
-
-		// Install
-		if *sudo {
-			installCmd := fmt.Sprintf(
-				"echo %s | sudo -S install -m 0755 -o root -g root %s %s",
-				shellQuote(*password),
-				shellQuote(remoteTmpPath),
-				shellQuote(remoteInstallPath),
-			)
-			if out, err := run(client, installCmd); err != nil {
-				log.Fatalf("install %s failed: %v\n%s", name, err, out)
-			}
+		// Skip payloads that are already installed
+		if sum, err := client.RemoteSHA256(remoteInstallPath); err == nil && sum == localSHA {
+			log.Printf("%s is up to date at %s (sha256 %s), skipping upload", name, remoteInstallPath, localSHA)
 		} else {
-			mvCmd := fmt.Sprintf("mv %s %s && chmod 0755 %s",
-				shellQuote(remoteTmpPath), shellQuote(remoteInstallPath), shellQuote(remoteInstallPath))
-			if out, err := run(client, mvCmd); err != nil {
-				log.Fatalf("move %s failed: %v\n%s", name, err, out)
-			}
-		}
-		log.Printf("installed %s to %s", name, remoteInstallPath)
-
-		// Verify checksum
-		localSHA := sha256.Sum256(data)
-		chkCmd := fmt.Sprintf(
-			"sha256sum %s || shasum -a 256 %s || openssl dgst -sha256 %s",
-			shellQuote(remoteInstallPath), shellQuote(remoteInstallPath), shellQuote(remoteInstallPath))
-		if out, err := run(client, chkCmd); err == nil {
-			log.Printf("remote checksum for %s:\n%s", name, strings.TrimSpace(out))
+			installPayload(client, name, data, localSHA, remoteTmpPath, remoteInstallPath, *sudo)
 		}
-		log.Printf("local  checksum for %s: %x", name, localSHA)
 
 		// Run self-test
-		testCmd := fmt.Sprintf("%s %s", shellQuote(remoteInstallPath), *selfTest)
-		if out, err := run(client, testCmd); err != nil {
+		testCmd := sshkit.NewCommand(remoteInstallPath, strings.Fields(*selfTest)...)
+		if out, err := client.Output(testCmd.String()); err != nil {
 			log.Fatalf("self-test %s failed: %v\n%s", name, err, out)
 		} else {
 			fmt.Printf("\n===== SELF-TEST OUTPUT for %s =====\n%s\n", name, out)
@@ -141,93 +135,43 @@ func main() {
 	}
 }
 
-// SSH configuration helper
-func sshConfig(user, keyPath, password string) (*ssh.ClientConfig, error) {
-	auths := []ssh.AuthMethod{}
-	if password != "" {
-		auths = append(auths, ssh.Password(password))
-	} else if keyPath != "" {
-		pem, err := os.ReadFile(keyPath)
-		if err != nil {
-			return nil, fmt.Errorf("read key: %w", err)
+// installPayload uploads data (verified, see sshkit.Client.Upload) to
+// remoteTmpPath, moves it to remoteInstallPath and checks the installed
+// file's SHA-256 against localSHA.
+func installPayload(client *sshkit.Client, name string, data []byte, localSHA, remoteTmpPath, remoteInstallPath string, sudo bool) {
+	// Upload
+	if err := client.Upload(bytes.NewReader(data), remoteTmpPath, 0o755); err != nil {
+		log.Fatalf("upload %s: %v", name, err)
+	}
+	log.Printf("uploaded %s to %s (%d bytes)", name, remoteTmpPath, len(data))
+
+	// Install
+	if sudo {
+		installCmd := client.AsRoot(sshkit.NewCommand(
+			"install", "-m", "0755", "-o", "root", "-g", "root", remoteTmpPath, remoteInstallPath,
+		).String())
+		if out, err := client.Output(installCmd); err != nil {
+			log.Fatalf("install %s failed: %v\n%s", name, err, out)
 		}
-		signer, err := ssh.ParsePrivateKey(pem)
-		if err != nil {
-			return nil, fmt.Errorf("parse key: %w", err)
+	} else {
+		mvCmd := sshkit.Script(`mv -- "$1" "$2" && chmod 0755 -- "$2"`, remoteTmpPath, remoteInstallPath)
+		if out, err := client.Output(mvCmd.String()); err != nil {
+			log.Fatalf("move %s failed: %v\n%s", name, err, out)
 		}
-		auths = append(auths, ssh.PublicKeys(signer))
-	}
-	return &ssh.ClientConfig{
-		User:            user,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}, nil
-}
-
-// SCP upload for binary files
-func scpUpload(client *ssh.Client, data []byte, remotePath string, mode os.FileMode) error {
-	sess, err := client.NewSession()
-	if err != nil {
-		return err
 	}
-	defer sess.Close()
+	log.Printf("installed %s to %s", name, remoteInstallPath)
 
-	var stderr bytes.Buffer
-	sess.Stderr = &stderr
-
-	stdin, err := sess.StdinPipe()
+	// Verify checksum of the installed file
+	remoteSHA, err := client.RemoteSHA256(remoteInstallPath)
 	if err != nil {
-		return fmt.Errorf("stdin pipe: %w", err)
+		log.Fatalf("verify %s: %v", name, err)
 	}
-
-	remoteDir := filepath.Dir(remotePath)
-	remoteFile := filepath.Base(remotePath)
-
-	if err := sess.Start("scp -t " + shellQuote(remoteDir)); err != nil {
-		return fmt.Errorf("start scp: %w (%s)", err, stderr.String())
+	if remoteSHA != localSHA {
+		log.Fatalf("verify %s: checksum mismatch: local %s, %s has %s", name, localSHA, remoteInstallPath, remoteSHA)
 	}
-
-	go func() {
-		defer stdin.Close()
-		fmt.Fprintf(stdin, "C%04o %d %s\n", mode&0o7777, len(data), remoteFile)
-		_, _ = stdin.Write(data)
-		stdin.Write([]byte{0}) // SCP EOF
-	}()
-
-	if err := sess.Wait(); err != nil {
-		return fmt.Errorf("scp wait: %w (%s)", err, stderr.String())
-	}
-
-	return nil
+	log.Printf("verified %s: sha256 %s", name, localSHA)
 }
 
-// Run a command over SSH
-func run(client *ssh.Client, cmd string) (string, error) {
-	sess, err := client.NewSession()
-	if err != nil {
-		return "", err
-	}
-	defer sess.Close()
-
-	var stdout, stderr bytes.Buffer
-	sess.Stdout = &stdout
-	sess.Stderr = &stderr
-
-	if err := sess.Run(cmd); err != nil {
-		return stdout.String() + stderr.String(), fmt.Errorf("remote cmd error: %w", err)
-	}
-	return stdout.String(), nil
-}
-
-// Shell-quote helper
-func shellQuote(s string) string {
-	if s == "" {
-		return "''"
-	}
-	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
-}
-
-
 /*
 Usage:
 
@@ -235,12 +179,10 @@ go mod init ssh_capsule_load_and_run
 go mod tidy
 go run main.go
 
- % go run main.go            
+ % go run main.go
 2025/09/04 23:44:48 uploaded sysinfo to /tmp/sysinfo (12336 bytes)
 2025/09/04 23:44:48 installed sysinfo to /usr/local/bin/sysinfo
-2025/09/04 23:44:48 remote checksum for sysinfo:
-9da89946c095256db6e82abf35bbb71842b4a6d7f92fa81a9e0b1c75eef9fba9  /usr/local/bin/sysinfo
-2025/09/04 23:44:48 local  checksum for sysinfo: 9da89946c095256db6e82abf35bbb71842b4a6d7f92fa81a9e0b1c75eef9fba9
+2025/09/04 23:44:48 verified sysinfo: sha256 9da89946c095256db6e82abf35bbb71842b4a6d7f92fa81a9e0b1c75eef9fba9
 
 ===== SELF-TEST OUTPUT for sysinfo =====
 Linux gpu-m 6.14.0-29-generic #29~24.04.1-Ubuntu SMP PREEMPT_DYNAMIC Thu Aug 14 16:52:50 UTC 2 x86_64 x86_64 x86_64 GNU/Linux
@@ -265,10 +207,14 @@ The Go program reads them via embed.FS.
 
 Uploads each to remoteTmp (default /tmp).
 
-Installs each to installDir (/usr/local/bin) with sudo if enabled.
+Installs each to installDir (/usr/local/bin) with sudo if enabled (-sudo-method doas|su
+also work); the password, SUDO_PASS or else the SSH password, goes to sudo's stdin only
+when it prompts, so it never shows in the remote process list.
 
-Verifies SHA256 checksum.
+Verifies the SHA256 checksum: the upload is hashed remotely before it is renamed into
+place, and the installed file must match the embedded payload, else the run fails.
+Payloads already installed with the same checksum are not uploaded again.
 
 Runs a self-test (--version by default, can be overridden via --self-test).
 
-*/
\ No newline at end of file
+*/
@@ -2,6 +2,15 @@ module single_install
 
 go 1.24.2
 
-require golang.org/x/crypto v0.41.0
+require sshkit v0.0.0
 
-require golang.org/x/sys v0.35.0 // indirect
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pkg/sftp v1.13.9 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/term v0.34.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace sshkit => ../sshkit
@@ -5,66 +5,121 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 // Package defines how to build/install a tarball
 type Package struct {
-	URL       string   // source URL
-	Tarball   string   // local tarball filename
-	DirName   string   // folder after extract
-	Configure string   // configure/cmake step
-	Build     string   // build step
-	Install   string   // install step
-	SelfTest  []string // post-install test commands
+	URL       string          // source URL
+	Tarball   string          // local tarball filename
+	DirName   string          // folder after extract
+	Configure *sshkit.Command // configure/cmake step
+	Build     *sshkit.Command // build step
+	Install   *sshkit.Command // install step, run as root with -sudo
+	SelfTest  []string        // post-install test command lines
 }
 
 func main() {
-	// SSH info from environment
-	hostEnv := os.Getenv("SSH_HOST")
-	userEnv := os.Getenv("SSH_USER")
+	// Read SSH info from environment; SUDO_PASS, when set, is the sudo
+	// password instead of SSH_PASS
 	passwordEnv := os.Getenv("SSH_PASS")
-	keyEnv := os.Getenv("SSH_KEY")
-
-	hostOnly := hostEnv
-	if hostEnv != "" && strings.Contains(hostEnv, "@") {
-		parts := strings.SplitN(hostEnv, "@", 2)
-		userEnv = parts[0]
-		hostOnly = parts[1]
-	}
-
-	if hostOnly == "" || userEnv == "" {
-		log.Fatal("SSH_HOST must be set (user@host or SSH_USER + SSH_HOST)")
+	if v := os.Getenv("SUDO_PASS"); v != "" {
+		passwordEnv = v
 	}
 
 	// CLI flags
 	remoteTmp := flag.String("remote-tmp", "/tmp", "Remote temp dir")
 	installDir := flag.String("install-dir", "/usr/local", "Install prefix")
 	sudo := flag.Bool("sudo", true, "Use sudo for install")
+	sudoMethod := flag.String("sudo-method", "sudo", "How to become root: sudo, doas or su")
+	inventory := flag.String("inventory", os.Getenv("SSH_INVENTORY"), "Inventory file (default $SSH_INVENTORY)")
+	hosts := flag.String("hosts", "", "Install on the inventory hosts matching this pattern (e.g. web:&prod:!web03) instead of SSH_HOST")
+	batch := flag.String("batch", "1", "Hosts to install on at once: a count or a percentage (20%)")
+	maxFail := flag.Int("max-fail", 0, "Stop the rollout once more than this many hosts have failed (-1: never)")
+	confirm := flag.Bool("confirm", false, "Ask before starting each batch after the first")
 	flag.Parse()
 
+	// SSH_HOST, with SSH_USER as the default user, unless -hosts is given
+	hostEnv := os.Getenv("SSH_HOST")
+	if *hosts == "" && hostEnv == "" {
+		log.Fatal("SSH_HOST must be set (~/.ssh/config alias, user@host or SSH_USER + SSH_HOST), or use -hosts")
+	}
+	if userEnv := os.Getenv("SSH_USER"); userEnv != "" && hostEnv != "" && !strings.Contains(hostEnv, "@") {
+		hostEnv = userEnv + "@" + hostEnv
+	}
+	targets, err := sshkit.SelectTargets(sshkit.DialerFromEnv(), *inventory, *hosts, hostEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ht := range targets {
+		if ht.Target.ConnectTimeout == 0 {
+			ht.Target.ConnectTimeout = 30 * time.Second
+		}
+	}
+
+	method, err := sshkit.ParseSudoMethod(*sudoMethod)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if *sudo && passwordEnv == "" {
-		log.Fatal("SSH_PASS must be set for sudo operations")
+		log.Printf("no SUDO_PASS or SSH_PASS: %s must not ask for a password (NOPASSWD)", method)
 	}
 
-	// SSH connection
-	cfg, err := sshConfig(userEnv, keyEnv, passwordEnv)
+	names := make([]string, len(targets))
+	for i, ht := range targets {
+		names[i] = ht.Name
+	}
+	rollout := &sshkit.Rollout{Batch: *batch, MaxFail: *maxFail, Confirm: *confirm}
+	// Upload progress only when hosts go one at a time; a batch would garble it.
+	var progress io.Writer
+	if size, err := rollout.BatchSize(len(targets)); err == nil && size == 1 {
+		progress = os.Stderr
+	}
+	results, err := rollout.Run(names, func(i int) error {
+		log.Printf("=== %s ===", targets[i].Name)
+		err := installHost(targets[i], progress, *remoteTmp, *installDir, *sudo, method, passwordEnv)
+		if err != nil {
+			log.Printf("%s: %v", targets[i].Name, err)
+		}
+		return err
+	})
+	if len(targets) > 1 {
+		sshkit.WriteRolloutSummary(os.Stderr, results)
+	}
 	if err != nil {
-		log.Fatalf("ssh config error: %v", err)
+		log.Fatal(err)
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
 	}
-	addr := net.JoinHostPort(hostOnly, "22")
-	client, err := ssh.Dial("tcp", addr, cfg)
+	if failed > 0 {
+		log.Fatalf("%d of %d host(s) failed", failed, len(results))
+	}
+
+	log.Println("All packages installed successfully!")
+}
+
+// installHost connects to one host and installs the packages in order
+func installHost(ht sshkit.HostTarget, progress io.Writer, remoteTmp, installDir string, sudo bool, sudoMethod sshkit.SudoMethod, sudoPassword string) error {
+	// SSH connection
+	client, err := ht.Dialer.Connect(ht.Target)
 	if err != nil {
-		log.Fatalf("ssh dial error: %v", err)
+		return fmt.Errorf("ssh dial error: %v", err)
 	}
 	defer client.Close()
+	client.UseSudo = sudo
+	client.SudoMethod = sudoMethod
+	client.SudoPassword = sudoPassword
+	client.Progress = progress
 
 	// Packages to install (order matters - dependencies first)
 	pkgs := []Package{
@@ -72,134 +127,91 @@ func main() {
 			URL:       "https://zlib.net/zlib-1.3.1.tar.gz",
 			Tarball:   "zlib-1.3.1.tar.gz",
 			DirName:   "zlib-1.3.1",
-			Configure: "./configure --prefix=" + *installDir,
-			Build:     "make -j$(nproc)",
-			Install:   chooseSudo(*sudo, "make install", passwordEnv),
-			SelfTest:  []string{chooseSudo(*sudo, "ldconfig", passwordEnv), "ldconfig -p | grep zlib || echo 'zlib not found in ldconfig'"},
+			Configure: sshkit.NewCommand("./configure", "--prefix="+installDir),
+			Build:     sshkit.Script(`make -j"$(nproc)"`),
+			Install:   sshkit.NewCommand("make", "install"),
+			SelfTest:  []string{client.AsRoot(sshkit.NewCommand("ldconfig").String()), "ldconfig -p | grep zlib || echo 'zlib not found in ldconfig'"},
 		},
 		{
 			URL:       "https://www.openssl.org/source/openssl-3.4.2.tar.gz",
 			Tarball:   "openssl-3.4.2.tar.gz",
 			DirName:   "openssl-3.4.2",
-			Configure: fmt.Sprintf("./config --prefix=%s --openssldir=%s/ssl enable-legacy shared", *installDir, *installDir),
-			Build:     "make -j$(nproc)",
-			Install:   chooseSudo(*sudo, "make install", passwordEnv),
+			Configure: sshkit.NewCommand("./config", "--prefix="+installDir, "--openssldir="+installDir+"/ssl", "enable-legacy", "shared"),
+			Build:     sshkit.Script(`make -j"$(nproc)"`),
+			Install:   sshkit.NewCommand("make", "install"),
 			SelfTest:  []string{}, // Will be set dynamically
 		},
 		{
 			URL:       "https://curl.se/download/curl-8.10.1.tar.gz",
 			Tarball:   "curl-8.10.1.tar.gz",
 			DirName:   "curl-8.10.1",
-			Configure: fmt.Sprintf("PKG_CONFIG_PATH=%s/lib/pkgconfig:$PKG_CONFIG_PATH ./configure --prefix=%s --with-openssl=%s", *installDir, *installDir, *installDir),
-			Build:     "make -j$(nproc)",
-			Install:   chooseSudo(*sudo, "make install", passwordEnv),
-			SelfTest:  []string{fmt.Sprintf("LD_LIBRARY_PATH=%s/lib:$LD_LIBRARY_PATH %s/bin/curl --version", *installDir, *installDir)},
+			Configure: sshkit.Script(`PKG_CONFIG_PATH="$1/lib/pkgconfig:$PKG_CONFIG_PATH" ./configure --prefix="$1" --with-openssl="$1"`, installDir),
+			Build:     sshkit.Script(`make -j"$(nproc)"`),
+			Install:   sshkit.NewCommand("make", "install"),
+			SelfTest:  []string{sshkit.Script(`LD_LIBRARY_PATH="$1/lib:$LD_LIBRARY_PATH" "$1/bin/curl" --version`, installDir).String()},
 		},
 		{
 			URL:       "https://github.com/vim/vim/archive/refs/tags/v9.1.0000.tar.gz",
 			Tarball:   "vim-9.1.0000.tar.gz",
 			DirName:   "vim-9.1.0000",
-			Configure: fmt.Sprintf("./configure --prefix=%s --enable-multibyte --enable-cscope", *installDir),
-			Build:     "make -j$(nproc)",
-			Install:   chooseSudo(*sudo, "make install", passwordEnv),
-			SelfTest:  []string{fmt.Sprintf("%s/bin/vim --version | head -n 3", *installDir)},
+			Configure: sshkit.NewCommand("./configure", "--prefix="+installDir, "--enable-multibyte", "--enable-cscope"),
+			Build:     sshkit.Script(`make -j"$(nproc)"`),
+			Install:   sshkit.NewCommand("make", "install"),
+			SelfTest:  []string{sshkit.Script(`"$1/bin/vim" --version | head -n 3`, installDir).String()},
 		},
 	}
 
 	// Install each package
 	for _, pkg := range pkgs {
-		if err := ensureTarball(pkg); err != nil {
-			log.Fatalf("download failed for %s: %v", pkg.URL, err)
-		}
-		if err := installPackage(client, *remoteTmp, *installDir, *sudo, passwordEnv, pkg); err != nil {
-			log.Fatalf("installation failed for %s: %v", pkg.Tarball, err)
+		if err := sshkit.FetchOnce(pkg.URL, pkg.Tarball); err != nil {
+			return fmt.Errorf("download failed for %s: %v", pkg.URL, err)
 		}
-	}
-
-	log.Println("All packages installed successfully!")
-}
-
-// SSH configuration
-func sshConfig(user, keyPath, password string) (*ssh.ClientConfig, error) {
-	var auths []ssh.AuthMethod
-	if keyPath != "" {
-		key, err := os.ReadFile(keyPath)
-		if err != nil {
-			return nil, err
-		}
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, err
+		if err := installPackage(client, remoteTmp, installDir, pkg); err != nil {
+			return fmt.Errorf("installation failed for %s: %v", pkg.Tarball, err)
 		}
-		auths = append(auths, ssh.PublicKeys(signer))
-	}
-	if password != "" {
-		auths = append(auths, ssh.Password(password))
-	}
-	return &ssh.ClientConfig{
-		User:            user,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}, nil
-}
-
-// Returns a sudo-wrapped command if requested
-func chooseSudo(use bool, cmd, password string) string {
-	if use {
-		return fmt.Sprintf("echo '%s' | sudo -S %s", password, cmd)
-	}
-	return cmd
-}
-
-// Download tarball if missing
-func ensureTarball(pkg Package) error {
-	if _, err := os.Stat(pkg.Tarball); os.IsNotExist(err) {
-		log.Printf("Downloading %s...", pkg.URL)
-		cmd := exec.Command("wget", "-O", pkg.Tarball, pkg.URL)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		return cmd.Run()
 	}
 	return nil
 }
 
 // Install a single package
-func installPackage(client *ssh.Client, remoteTmp, installDir string, sudo bool, password string, pkg Package) error {
+func installPackage(client *sshkit.Client, remoteTmp, installDir string, pkg Package) error {
 	remoteTar := filepath.Join(remoteTmp, filepath.Base(pkg.Tarball))
 	log.Printf("Uploading %s...", pkg.Tarball)
-	if err := scpFile(client, pkg.Tarball, remoteTar); err != nil {
+	if err := client.UploadFile(pkg.Tarball, remoteTar, 0644); err != nil {
 		return err
 	}
 
+	srcDir := path.Join(remoteTmp, pkg.DirName)
 	steps := []string{
-		fmt.Sprintf("cd %s && tar -xzf %s", remoteTmp, filepath.Base(pkg.Tarball)),
-		fmt.Sprintf("cd %s/%s && %s", remoteTmp, pkg.DirName, pkg.Configure),
-		fmt.Sprintf("cd %s/%s && %s", remoteTmp, pkg.DirName, pkg.Build),
-		fmt.Sprintf("cd %s/%s && %s", remoteTmp, pkg.DirName, pkg.Install),
+		sshkit.NewCommand("tar", "-xzf", filepath.Base(pkg.Tarball)).In(remoteTmp).String(),
+		pkg.Configure.In(srcDir).String(),
+		pkg.Build.In(srcDir).String(),
+		client.AsRoot(pkg.Install.In(srcDir).String()),
 	}
 
 	// Special handling for OpenSSL
-	if strings.Contains(strings.ToLower(pkg.Tarball), "openssl") && sudo {
+	if strings.Contains(strings.ToLower(pkg.Tarball), "openssl") && client.UseSudo {
 		// Check both lib and lib64 directories and configure ldconfig
 		steps = append(steps,
 			// Create ldconfig entry - try both lib and lib64
-			fmt.Sprintf("if [ -d %s/lib ]; then %s; fi", installDir, 
-				chooseSudo(sudo, fmt.Sprintf("sh -c 'echo %s/lib > /etc/ld.so.conf.d/openssl.conf'", installDir), password)),
-			fmt.Sprintf("if [ -d %s/lib64 ]; then %s; fi", installDir,
-				chooseSudo(sudo, fmt.Sprintf("sh -c 'echo %s/lib64 >> /etc/ld.so.conf.d/openssl.conf'", installDir), password)),
-			chooseSudo(sudo, "ldconfig -v", password),
+			client.AsRoot(sshkit.Script(
+				`if [ -d "$1/lib" ]; then echo "$1/lib" > /etc/ld.so.conf.d/openssl.conf; fi; `+
+					`if [ -d "$1/lib64" ]; then echo "$1/lib64" >> /etc/ld.so.conf.d/openssl.conf; fi`,
+				installDir,
+			).String()),
+			client.AsRoot(sshkit.NewCommand("ldconfig", "-v").String()),
 		)
-		
+
 		// Set up multiple test approaches for OpenSSL
 		pkg.SelfTest = []string{
 			// Test 1: Try with LD_LIBRARY_PATH pointing to lib
-			fmt.Sprintf("LD_LIBRARY_PATH=%s/lib:$LD_LIBRARY_PATH %s/bin/openssl version || echo 'Test 1 failed'", installDir, installDir),
+			sshkit.Script(`LD_LIBRARY_PATH="$1/lib:$LD_LIBRARY_PATH" "$1/bin/openssl" version || echo 'Test 1 failed'`, installDir).String(),
 			// Test 2: Try with LD_LIBRARY_PATH pointing to lib64
-			fmt.Sprintf("LD_LIBRARY_PATH=%s/lib64:$LD_LIBRARY_PATH %s/bin/openssl version || echo 'Test 2 failed'", installDir, installDir),
+			sshkit.Script(`LD_LIBRARY_PATH="$1/lib64:$LD_LIBRARY_PATH" "$1/bin/openssl" version || echo 'Test 2 failed'`, installDir).String(),
 			// Test 3: Try without LD_LIBRARY_PATH (using ldconfig)
-			fmt.Sprintf("%s/bin/openssl version || echo 'Test 3 failed'", installDir),
+			sshkit.Script(`"$1/bin/openssl" version || echo 'Test 3 failed'`, installDir).String(),
 			// Diagnostic: Show what libraries are available
-			fmt.Sprintf("ls -la %s/lib*/libssl* %s/lib*/libcrypto* 2>/dev/null || echo 'No SSL libraries found'", installDir, installDir),
+			sshkit.Script(`ls -la "$1"/lib*/libssl* "$1"/lib*/libcrypto* 2>/dev/null || echo 'No SSL libraries found'`, installDir).String(),
 		}
 	}
 
@@ -226,43 +238,14 @@ func installPackage(client *ssh.Client, remoteTmp, installDir string, sudo bool,
 	return nil
 }
 
-// Copy local file to remote via SCP
-func scpFile(client *ssh.Client, localPath, remotePath string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	srcFile, err := os.Open(localPath)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	info, _ := srcFile.Stat()
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
-		fmt.Fprintf(w, "C0644 %d %s\n", info.Size(), filepath.Base(localPath))
-		io.Copy(w, srcFile)
-		fmt.Fprint(w, "\x00")
-	}()
-	return session.Run(fmt.Sprintf("scp -tr %s", filepath.Dir(remotePath)))
-}
-
 // Execute remote command via SSH
-func runRemote(client *ssh.Client, cmd string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	log.Printf("Running: %s", cmd)
-	return session.Run(cmd)
+// (output is streamed; a failure's error quotes the tail of it)
+func runRemote(client *sshkit.Client, cmd string) error {
+	host := client.Target.Alias
+	log.Printf("[%s] Running: %s", host, cmd)
+	stdout := sshkit.NewPrefixWriter(os.Stdout, "["+host+"] ")
+	stderr := sshkit.NewPrefixWriter(os.Stderr, "["+host+"] ")
+	return client.Run(cmd, stdout, stderr)
 }
 
 /*
@@ -276,19 +259,31 @@ Environment variables:
   SSH_HOST=user@host (or just host with SSH_USER set separately)
   SSH_USER=username (if not in SSH_HOST)
   SSH_PASS=password
+  SUDO_PASS=password (optional, sudo password when it differs from SSH_PASS; unset for NOPASSWD)
   SSH_KEY=/path/to/key (optional, alternative to password)
+  SSH_JUMP=user@bastion1,user@bastion2 (optional jump hosts, overrides ProxyJump)
+  SSH_JUMP_KEY=/path/to/bastion_key (optional, key for the jump hosts, SSH_JUMP or ProxyJump, instead of SSH_KEY)
+  SSH_KEEPALIVE=30s (optional keepalive interval, off to disable; a dead connection fails the running step)
+  SSH_RECONNECT=3 (optional, redials of a dropped connection before the next step; 0 for none)
+  SSH_INVENTORY=hosts.yaml (optional, for -hosts; see sshkit.LoadInventory for the format)
 
 Optional flags:
   -remote-tmp /tmp
   -install-dir /usr/local
   -sudo=true
+  -sudo-method sudo | doas | su
+  -hosts 'web:&prod:!web03'   (inventory hosts to install on)
+  -batch 2 | -batch 20%       (hosts installed at once, default 1)
+  -max-fail 0                 (stop once more hosts than this have failed; -1 never)
+  -confirm                    (ask before each batch after the first)
 
 Key improvements:
 - Proper dependency order (zlib before OpenSSL, OpenSSL before curl)
 - Better OpenSSL configuration with shared libraries
 - Multiple test approaches for OpenSSL
 - PKG_CONFIG_PATH for curl to find OpenSSL
-- Proper shell quoting for passwords
+- sudo password written to stdin only when prompted, never put on the command line
+- Tarball uploads show progress with -batch 1 and resume where an interrupted one stopped
 - Better error handling and diagnostics
 - LD_LIBRARY_PATH for applications that need it
-*/
\ No newline at end of file
+*/
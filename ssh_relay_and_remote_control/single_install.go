@@ -5,66 +5,119 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 // Package defines how to build/install a tarball
 type Package struct {
-	URL       string   // source URL
-	Tarball   string   // local tarball filename
-	DirName   string   // expected folder after extract
-	Configure string   // configure or cmake step
-	Build     string   // build step
-	Install   string   // install step
-	SelfTest  []string // test commands after install
+	URL       string          // source URL
+	Tarball   string          // local tarball filename
+	DirName   string          // expected folder after extract
+	Configure *sshkit.Command // configure or cmake step
+	Build     *sshkit.Command // build step
+	Install   *sshkit.Command // install step, run as root with -sudo
+	SelfTest  []string        // test command lines after install
 }
 
 func main() {
-	// Read SSH info from environment
-	hostEnv := os.Getenv("SSH_HOST")
-	userEnv := os.Getenv("SSH_USER")
+	// Read SSH info from environment; SUDO_PASS, when set, is the sudo
+	// password instead of SSH_PASS
 	passwordEnv := os.Getenv("SSH_PASS")
-	keyEnv := os.Getenv("SSH_KEY")
-
-	hostOnly := hostEnv
-	if hostEnv != "" && strings.Contains(hostEnv, "@") {
-		parts := strings.SplitN(hostEnv, "@", 2)
-		userEnv = parts[0]
-		hostOnly = parts[1]
-	}
-
-	if hostOnly == "" || userEnv == "" {
-		log.Fatal("SSH_HOST must be set (user@host or host + SSH_USER env)")
+	if v := os.Getenv("SUDO_PASS"); v != "" {
+		passwordEnv = v
 	}
 
 	// CLI flags
 	remoteTmp := flag.String("remote-tmp", "/tmp", "Remote temp dir")
 	installDir := flag.String("install-dir", "/usr/local", "Install prefix")
 	sudo := flag.Bool("sudo", true, "Use sudo for install")
+	sudoMethod := flag.String("sudo-method", "sudo", "How to become root: sudo, doas or su")
+	inventory := flag.String("inventory", os.Getenv("SSH_INVENTORY"), "Inventory file (default $SSH_INVENTORY)")
+	hosts := flag.String("hosts", "", "Install on the inventory hosts matching this pattern (e.g. web:&prod:!web03) instead of SSH_HOST")
+	batch := flag.String("batch", "1", "Hosts to install on at once: a count or a percentage (20%)")
+	maxFail := flag.Int("max-fail", 0, "Stop the rollout once more than this many hosts have failed (-1: never)")
+	confirm := flag.Bool("confirm", false, "Ask before starting each batch after the first")
 	flag.Parse()
 
+	// SSH_HOST, with SSH_USER as the default user, unless -hosts is given
+	hostEnv := os.Getenv("SSH_HOST")
+	if *hosts == "" && hostEnv == "" {
+		log.Fatal("SSH_HOST must be set (~/.ssh/config alias, user@host or host + SSH_USER env), or use -hosts")
+	}
+	if userEnv := os.Getenv("SSH_USER"); userEnv != "" && hostEnv != "" && !strings.Contains(hostEnv, "@") {
+		hostEnv = userEnv + "@" + hostEnv
+	}
+	targets, err := sshkit.SelectTargets(sshkit.DialerFromEnv(), *inventory, *hosts, hostEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ht := range targets {
+		if ht.Target.ConnectTimeout == 0 {
+			ht.Target.ConnectTimeout = 30 * time.Second
+		}
+	}
+
+	method, err := sshkit.ParseSudoMethod(*sudoMethod)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if *sudo && passwordEnv == "" {
-		log.Fatal("SSH_PASS must be set in env for sudo")
+		log.Printf("no SUDO_PASS or SSH_PASS: %s must not ask for a password (NOPASSWD)", method)
 	}
 
-	// SSH config
-	cfg, err := sshConfig(userEnv, keyEnv, passwordEnv)
+	names := make([]string, len(targets))
+	for i, ht := range targets {
+		names[i] = ht.Name
+	}
+	rollout := &sshkit.Rollout{Batch: *batch, MaxFail: *maxFail, Confirm: *confirm}
+	// Upload progress only when hosts go one at a time; a batch would garble it.
+	var progress io.Writer
+	if size, err := rollout.BatchSize(len(targets)); err == nil && size == 1 {
+		progress = os.Stderr
+	}
+	results, err := rollout.Run(names, func(i int) error {
+		log.Printf("=== %s ===", targets[i].Name)
+		err := installHost(targets[i], progress, *remoteTmp, *installDir, *sudo, method, passwordEnv)
+		if err != nil {
+			log.Printf("%s: %v", targets[i].Name, err)
+		}
+		return err
+	})
+	if len(targets) > 1 {
+		sshkit.WriteRolloutSummary(os.Stderr, results)
+	}
 	if err != nil {
-		log.Fatalf("ssh config error: %v", err)
+		log.Fatal(err)
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d host(s) failed", failed, len(results))
 	}
-	addr := net.JoinHostPort(hostOnly, "22")
-	client, err := ssh.Dial("tcp", addr, cfg)
+}
+
+// installHost connects to one host and installs every package on it
+func installHost(ht sshkit.HostTarget, progress io.Writer, remoteTmp, installDir string, sudo bool, sudoMethod sshkit.SudoMethod, sudoPassword string) error {
+	// SSH config
+	client, err := ht.Dialer.Connect(ht.Target)
 	if err != nil {
-		log.Fatalf("ssh dial: %v", err)
+		return fmt.Errorf("ssh dial: %v", err)
 	}
 	defer client.Close()
+	client.UseSudo = sudo
+	client.SudoMethod = sudoMethod
+	client.SudoPassword = sudoPassword
+	client.Progress = progress
 
 	// Define packages
 	pkgs := []Package{
@@ -72,105 +125,62 @@ func main() {
 			URL:       "https://www.openssl.org/source/openssl-3.4.2.tar.gz",
 			Tarball:   "openssl-3.4.2.tar.gz",
 			DirName:   "openssl-3.4.2",
-			Configure: fmt.Sprintf("./config --prefix=%s --openssldir=%s/ssl enable-legacy shared", *installDir, *installDir),
-			Build:     "make -j$(nproc)",
-			Install:   chooseSudo(*sudo, "make install", passwordEnv),
+			Configure: sshkit.NewCommand("./config", "--prefix="+installDir, "--openssldir="+installDir+"/ssl", "enable-legacy", "shared"),
+			Build:     sshkit.Script(`make -j"$(nproc)"`),
+			Install:   sshkit.NewCommand("make", "install"),
 			SelfTest:  []string{}, // Will be populated dynamically
 		},
 	}
 
 	// Process packages
 	for _, pkg := range pkgs {
-		if err := ensureTarball(pkg); err != nil {
-			log.Fatalf("download failed for %s: %v", pkg.URL, err)
-		}
-		if err := installPackage(client, *remoteTmp, pkg, *sudo, passwordEnv, *installDir); err != nil {
-			log.Fatalf("failed installing %s: %v", pkg.Tarball, err)
-		}
-	}
-}
-
-// sshConfig prepares SSH client config
-func sshConfig(user, keyPath, password string) (*ssh.ClientConfig, error) {
-	var auths []ssh.AuthMethod
-	if keyPath != "" {
-		key, err := os.ReadFile(keyPath)
-		if err != nil {
-			return nil, err
+		if err := sshkit.FetchOnce(pkg.URL, pkg.Tarball); err != nil {
+			return fmt.Errorf("download failed for %s: %v", pkg.URL, err)
 		}
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, err
+		if err := installPackage(client, remoteTmp, pkg, installDir); err != nil {
+			return fmt.Errorf("failed installing %s: %v", pkg.Tarball, err)
 		}
-		auths = append(auths, ssh.PublicKeys(signer))
-	}
-	if password != "" {
-		auths = append(auths, ssh.Password(password))
-	}
-	return &ssh.ClientConfig{
-		User:            user,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}, nil
-}
-
-// chooseSudo wraps a command in sudo with password
-func chooseSudo(use bool, cmd, password string) string {
-	if use {
-		return fmt.Sprintf("echo %s | sudo -S %s", password, cmd)
-	}
-	return cmd
-}
-
-// ensureTarball downloads tarball if missing
-func ensureTarball(pkg Package) error {
-	if _, err := os.Stat(pkg.Tarball); os.IsNotExist(err) {
-		log.Printf("Downloading %s...", pkg.URL)
-		cmd := exec.Command("wget", "-O", pkg.Tarball, pkg.URL)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		return cmd.Run()
 	}
 	return nil
 }
 
 // installPackage uploads, builds, installs, and tests the package
-func installPackage(client *ssh.Client, remoteTmp string, pkg Package, sudo bool, password, installDir string) error {
+func installPackage(client *sshkit.Client, remoteTmp string, pkg Package, installDir string) error {
 	remoteTar := filepath.Join(remoteTmp, filepath.Base(pkg.Tarball))
 	log.Printf("Uploading %s...", pkg.Tarball)
-	if err := scpFile(client, pkg.Tarball, remoteTar); err != nil {
+	if err := client.UploadFile(pkg.Tarball, remoteTar, 0644); err != nil {
 		return err
 	}
 
+	srcDir := path.Join(remoteTmp, pkg.DirName)
 	steps := []string{
-		fmt.Sprintf("cd %s && tar -xzf %s", remoteTmp, filepath.Base(pkg.Tarball)),
-		fmt.Sprintf("cd %s/%s && %s", remoteTmp, pkg.DirName, pkg.Configure),
-		fmt.Sprintf("cd %s/%s && %s", remoteTmp, pkg.DirName, pkg.Build),
-		fmt.Sprintf("cd %s/%s && %s", remoteTmp, pkg.DirName, pkg.Install),
+		sshkit.NewCommand("tar", "-xzf", filepath.Base(pkg.Tarball)).In(remoteTmp).String(),
+		pkg.Configure.In(srcDir).String(),
+		pkg.Build.In(srcDir).String(),
+		client.AsRoot(pkg.Install.In(srcDir).String()),
 	}
 
 	// Library configuration steps
-	if sudo {
+	if client.UseSudo {
 		// Try multiple library paths - OpenSSL might install to lib64 on some systems
 		libPaths := []string{
-			fmt.Sprintf("%s/lib", installDir),
-			fmt.Sprintf("%s/lib64", installDir),
+			path.Join(installDir, "lib"),
+			path.Join(installDir, "lib64"),
 		}
-		
+
 		// Create ld.so.conf entries for both possible paths
 		for _, libPath := range libPaths {
 			steps = append(steps,
 				// Check if the lib directory exists and add it to ld.so.conf
-				fmt.Sprintf("[ -d %s ] && %s || echo 'Directory %s does not exist'", 
-					libPath, 
-					chooseSudo(sudo, fmt.Sprintf("sh -c 'echo %s > /etc/ld.so.conf.d/openssl.conf'", libPath), password),
+				client.AsRoot(sshkit.Script(
+					`if [ -d "$1" ]; then echo "$1" > /etc/ld.so.conf.d/openssl.conf; else echo "Directory $1 does not exist"; fi`,
 					libPath,
-				),
+				).String()),
 			)
 		}
-		
+
 		// Update ldconfig
-		steps = append(steps, chooseSudo(sudo, "ldconfig -v", password))
+		steps = append(steps, client.AsRoot(sshkit.NewCommand("ldconfig", "-v").String()))
 	}
 
 	// Execute build and install steps
@@ -181,15 +191,16 @@ func installPackage(client *ssh.Client, remoteTmp string, pkg Package, sudo bool
 	}
 
 	// Now try multiple approaches to test OpenSSL
+	openssl := path.Join(installDir, "bin/openssl")
 	testApproaches := []string{
 		// Approach 1: Use LD_LIBRARY_PATH with lib
-		fmt.Sprintf("LD_LIBRARY_PATH=%s/lib:$LD_LIBRARY_PATH %s/bin/openssl version", installDir, installDir),
+		sshkit.Script(`LD_LIBRARY_PATH="$1/lib:$LD_LIBRARY_PATH" "$1/bin/openssl" version`, installDir).String(),
 		// Approach 2: Use LD_LIBRARY_PATH with lib64
-		fmt.Sprintf("LD_LIBRARY_PATH=%s/lib64:$LD_LIBRARY_PATH %s/bin/openssl version", installDir, installDir),
+		sshkit.Script(`LD_LIBRARY_PATH="$1/lib64:$LD_LIBRARY_PATH" "$1/bin/openssl" version`, installDir).String(),
 		// Approach 3: Try without LD_LIBRARY_PATH (in case ldconfig worked)
-		fmt.Sprintf("%s/bin/openssl version", installDir),
+		sshkit.NewCommand(openssl, "version").String(),
 		// Approach 4: Check what libraries the binary is trying to load
-		fmt.Sprintf("ldd %s/bin/openssl", installDir),
+		sshkit.NewCommand("ldd", openssl).String(),
 	}
 
 	log.Printf("Testing OpenSSL installation...")
@@ -208,8 +219,8 @@ func installPackage(client *ssh.Client, remoteTmp string, pkg Package, sudo bool
 	// If all tests failed, run diagnostic commands
 	log.Printf("All tests failed, running diagnostics...")
 	diagnostics := []string{
-		"ls -la " + installDir + "/lib*",
-		"ls -la " + installDir + "/bin/openssl",
+		sshkit.Script(`ls -la "$1"/lib*`, installDir).String(),
+		sshkit.NewCommand("ls", "-la", openssl).String(),
 		"cat /etc/ld.so.conf.d/openssl.conf || echo 'openssl.conf not found'",
 		"ldconfig -p | grep ssl || echo 'No ssl libs found in ldconfig'",
 	}
@@ -222,46 +233,16 @@ func installPackage(client *ssh.Client, remoteTmp string, pkg Package, sudo bool
 	return fmt.Errorf("all OpenSSL tests failed, last error: %v", lastErr)
 }
 
-// scpFile uploads a local file to remote via SCP
-func scpFile(client *ssh.Client, localPath, remotePath string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	srcFile, err := os.Open(localPath)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	info, _ := srcFile.Stat()
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
-		fmt.Fprintf(w, "C0644 %d %s\n", info.Size(), filepath.Base(localPath))
-		io.Copy(w, srcFile)
-		fmt.Fprint(w, "\x00")
-	}()
-	return session.Run(fmt.Sprintf("scp -tr %s", filepath.Dir(remotePath)))
-}
-
 // runRemote executes a command over SSH
-func runRemote(client *ssh.Client, cmd string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	log.Printf("Running: %s", cmd)
-	return session.Run(cmd)
+// (output is streamed; a failure's error quotes the tail of it)
+func runRemote(client *sshkit.Client, cmd string) error {
+	host := client.Target.Alias
+	log.Printf("[%s] Running: %s", host, cmd)
+	stdout := sshkit.NewPrefixWriter(os.Stdout, "["+host+"] ")
+	stderr := sshkit.NewPrefixWriter(os.Stderr, "["+host+"] ")
+	return client.Run(cmd, stdout, stderr)
 }
 
-
 /*
 go mod init single_install
 go mod tidy
@@ -269,9 +250,14 @@ go run single_install.go
 
 🔑 Key Points:
 - Pre-download OpenSSL tarball on Mac (wget won’t work inside VM without net)
-- Uploads the tarball via SCP
+- Uploads the tarball over SFTP with progress, resuming an interrupted upload
 - Extracts it in /tmp
 - Runs ./config, make, sudo make install
 - Runs openssl version with LD_LIBRARY_PATH to pick up the new libraries
 - Works with either SSH password or SSH key
+- sudo (or -sudo-method doas|su) gets SUDO_PASS, else SSH_PASS, on stdin only when it prompts;
+  with neither set the host must allow it without a password (NOPASSWD)
+- -hosts 'web:&prod:!web03' installs on inventory hosts (-inventory or SSH_INVENTORY) in rolling
+  batches: -batch 2 or -batch 20% (default 1), -max-fail N stops once more than N hosts failed,
+  -confirm asks before each further batch
 */
@@ -0,0 +1,334 @@
+package sshkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// Auth method names accepted in Auth.Order and SSH_AUTH_ORDER.
+const (
+	AuthAgent               = "agent"
+	AuthKey                 = "key"
+	AuthKeyboardInteractive = "keyboard-interactive"
+	AuthPassword            = "password"
+)
+
+// DefaultAuthOrder is the fallback chain used when SSH_AUTH_ORDER is unset.
+var DefaultAuthOrder = []string{AuthAgent, AuthKey, AuthKeyboardInteractive, AuthPassword}
+
+// Auth describes the credentials to offer and the order to offer them in.
+type Auth struct {
+	Order      []string
+	KeyFiles   []string // private keys, ~/.ssh/id_* when empty; may be passphrase protected
+	Passphrase string   // passphrase for KeyFiles, prompted for when empty
+	Password   string   // used for password and keyboard-interactive auth
+	AgentSock  string   // ssh-agent socket, normally $SSH_AUTH_SOCK
+}
+
+// AuthFromEnv builds an Auth from the environment:
+//
+//	SSH_AUTH_ORDER      comma-separated methods (default agent,key,keyboard-interactive,password)
+//	SSH_KEY             private key path(s), separated by the OS path list separator;
+//	                    IdentityFile entries from ~/.ssh/config are tried after these,
+//	                    and ~/.ssh/id_ed25519, id_ecdsa and id_rsa when there are none
+//	SSH_KEY_PASSPHRASE  passphrase for SSH_KEY
+//	SSH_PASS            password
+//	SSH_AUTH_SOCK       ssh-agent socket
+func AuthFromEnv() *Auth {
+	a := &Auth{
+		Order:      DefaultAuthOrder,
+		Passphrase: os.Getenv("SSH_KEY_PASSPHRASE"),
+		Password:   os.Getenv("SSH_PASS"),
+		AgentSock:  os.Getenv("SSH_AUTH_SOCK"),
+	}
+	if o := os.Getenv("SSH_AUTH_ORDER"); o != "" {
+		a.Order = nil
+		for _, m := range strings.Split(o, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				a.Order = append(a.Order, m)
+			}
+		}
+	}
+	if k := os.Getenv("SSH_KEY"); k != "" {
+		for _, p := range filepath.SplitList(k) {
+			a.KeyFiles = append(a.KeyFiles, expandHome(p))
+		}
+	}
+	return a
+}
+
+// Methods returns the ssh.AuthMethods for a.Order, skipping methods that
+// have nothing to offer. The agent and key file signers share a single
+// publickey method because the ssh package tries each method name once.
+// A connection they open to the ssh-agent is left open; Dialer closes the
+// ones of its handshakes when the client closes.
+func (a *Auth) Methods() ([]ssh.AuthMethod, error) {
+	return a.methods(nil)
+}
+
+// methods is Methods, adding the ssh-agent connections it opens to
+// agents when that is not nil.
+func (a *Auth) methods(agents *connSet) ([]ssh.AuthMethod, error) {
+	keyFiles := a.KeyFiles
+	if len(keyFiles) == 0 {
+		keyFiles = defaultKeyFiles()
+	}
+	var methods []ssh.AuthMethod
+	var signers []func() ([]ssh.Signer, error)
+	publicKeyAt := -1
+
+	addSigners := func(f func() ([]ssh.Signer, error)) {
+		signers = append(signers, f)
+		if publicKeyAt < 0 {
+			publicKeyAt = len(methods)
+			methods = append(methods, nil)
+		}
+	}
+
+	for _, m := range a.Order {
+		switch m {
+		case AuthAgent:
+			if a.AgentSock != "" {
+				addSigners(func() ([]ssh.Signer, error) { return a.agentSigners(agents) })
+			}
+		case AuthKey:
+			if len(keyFiles) > 0 {
+				addSigners(func() ([]ssh.Signer, error) { return a.keySigners(keyFiles) })
+			}
+		case AuthKeyboardInteractive:
+			if a.Password != "" || canPrompt() {
+				methods = append(methods, ssh.KeyboardInteractive(a.challenge))
+			}
+		case AuthPassword:
+			if a.Password != "" {
+				methods = append(methods, ssh.Password(a.Password))
+			} else if canPrompt() {
+				methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+					return readSecret("Password: ")
+				}))
+			}
+		default:
+			return nil, fmt.Errorf("unknown auth method %q", m)
+		}
+	}
+	if publicKeyAt >= 0 {
+		methods[publicKeyAt] = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var all []ssh.Signer
+			var errs []error
+			for _, f := range signers {
+				s, err := f()
+				if err != nil {
+					errs = append(errs, err)
+				}
+				all = append(all, s...)
+			}
+			if len(all) == 0 && len(errs) > 0 {
+				return nil, errors.Join(errs...)
+			}
+			return all, nil
+		})
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no usable auth method: set SSH_KEY, SSH_PASS or SSH_AUTH_SOCK")
+	}
+	return methods, nil
+}
+
+// agentSigners returns the keys held by the ssh-agent, adding the
+// connection to them to agents.
+func (a *Auth) agentSigners(agents *connSet) ([]ssh.Signer, error) {
+	conn, err := net.Dial("unix", a.AgentSock)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	// The connection stays open: signing happens after this returns.
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	agents.add(conn)
+	return signers, nil
+}
+
+// connSet holds the ssh-agent connections opened for one connection's
+// handshake, to be closed with it. A nil *connSet keeps nothing.
+type connSet struct {
+	mu    sync.Mutex
+	conns []io.Closer
+}
+
+func (s *connSet) add(c io.Closer) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+}
+
+// Close closes the connections.
+func (s *connSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+	return nil
+}
+
+// keySigners loads the key files, decrypting them with the passphrase.
+func (a *Auth) keySigners(files []string) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	var errs []error
+	for _, p := range files {
+		s, err := a.loadKey(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		signers = append(signers, s)
+	}
+	if len(signers) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return signers, nil
+}
+
+func (a *Auth) loadKey(path string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	pass := a.Passphrase
+	if pass == "" {
+		if !canPrompt() {
+			return nil, fmt.Errorf("key %s is passphrase protected: set SSH_KEY_PASSPHRASE", path)
+		}
+		if pass, err = readSecret(fmt.Sprintf("Enter passphrase for key '%s': ", path)); err != nil {
+			return nil, err
+		}
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(pass))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// challenge answers keyboard-interactive prompts. Hidden prompts are
+// answered with the password when one is configured; anything else is
+// asked on the terminal.
+func (a *Auth) challenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	if len(questions) > 0 && (name != "" || instruction != "") && canPrompt() {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(name+"\n"+instruction))
+	}
+	for i, q := range questions {
+		if !echos[i] && a.Password != "" && strings.Contains(strings.ToLower(q), "password") {
+			answers[i] = a.Password
+			continue
+		}
+		if !canPrompt() {
+			return nil, fmt.Errorf("keyboard-interactive prompt %q needs a terminal", q)
+		}
+		var err error
+		if echos[i] {
+			answers[i], err = readLine(q)
+		} else {
+			answers[i], err = readSecret(q)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
+
+// defaultKeyFiles returns the standard identity files that exist.
+func defaultKeyFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		p := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// canPrompt reports whether a controlling terminal is available.
+func canPrompt() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
+
+// readSecret prompts on the terminal and reads a line without echo.
+func readSecret(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("open terminal: %w", err)
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	b, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", strings.TrimSpace(prompt), err)
+	}
+	return string(b), nil
+}
+
+// readLine prompts on the terminal and reads an echoed line.
+func readLine(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("open terminal: %w", err)
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	var b []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			// Without a newline there is no answer, not an empty one.
+			return "", fmt.Errorf("read %s: %w", strings.TrimSpace(prompt), err)
+		}
+		if n == 0 {
+			continue
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		b = append(b, buf[0])
+	}
+	return strings.TrimRight(string(b), "\r"), nil
+}
@@ -0,0 +1,170 @@
+package sshkit
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrChecksumMismatch is wrapped by the error of an upload or download
+// whose copy does not hash to the same as its source.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// errNoRemoteHash is wrapped by remoteSHA256's error when the host cannot
+// run sha256Script: it refuses exec requests, forces another command
+// (ForceCommand internal-sftp) or has no hashing tool.
+var errNoRemoteHash = errors.New("cannot hash on the remote host")
+
+// sha256Func defines h, which prints the SHA-256 of its standard input
+// with whichever tool the host has.
+const sha256Func = `h() {
+	if command -v sha256sum >/dev/null 2>&1; then sha256sum
+	elif command -v shasum >/dev/null 2>&1; then shasum -a 256
+	elif command -v openssl >/dev/null 2>&1; then openssl dgst -sha256 -r
+	else echo "no sha256sum, shasum or openssl" >&2; return 127
+	fi
+}
+`
+
+// sha256Script prints the SHA-256 of $1, or of its first $2 bytes.
+const sha256Script = sha256Func + `[ -f "$1" ] || { echo "$1: not a regular file" >&2; exit 1; }
+if [ -n "$2" ]; then head -c "$2" < "$1" | h; else h < "$1"; fi`
+
+// RemoteSHA256 returns the hex SHA-256 of remotePath, computed on the
+// remote host.
+func (c *Client) RemoteSHA256(remotePath string) (string, error) {
+	return c.remoteSHA256(remotePath, -1)
+}
+
+// remoteSHA256 is RemoteSHA256 of the first n bytes, or of the whole file
+// when n is negative. Once the host has turned out unable to hash, it
+// fails with errNoRemoteHash without trying again.
+func (c *Client) remoteSHA256(remotePath string, n int64) (string, error) {
+	if c.noHash.Load() {
+		return "", fmt.Errorf("sha256 %s: %w", remotePath, errNoRemoteHash)
+	}
+	limit := ""
+	if n >= 0 {
+		limit = strconv.FormatInt(n, 10)
+	}
+	out, err := c.Output(Script(sha256Script, remotePath, limit).String())
+	var ce *CommandError
+	switch {
+	case errors.Is(err, errExecRefused), errors.As(err, &ce) && ce.ExitCode == 127:
+		c.noHash.Store(true)
+		return "", fmt.Errorf("sha256 %s: %w: %w", remotePath, errNoRemoteHash, err)
+	case err != nil:
+		return "", fmt.Errorf("sha256 %s: %w", remotePath, err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		// Something other than the script ran and exited 0.
+		c.noHash.Store(true)
+		return "", fmt.Errorf("sha256 %s: %w: unexpected output %q", remotePath, errNoRemoteHash, strings.TrimSpace(out))
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// verifyRemote checks that remotePath hashes to want, the hex SHA-256 of
+// what was sent or received (verb). When the host cannot hash it, the
+// transfer is let through unverified and a warning is logged, once per
+// Client.
+func (c *Client) verifyRemote(remotePath, want, verb string) error {
+	sum, err := c.remoteSHA256(remotePath, -1)
+	if errors.Is(err, errNoRemoteHash) {
+		if c.hashWarned.CompareAndSwap(false, true) {
+			log.Printf("%s: transfers are not verified: %v", c.Target, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if sum != want {
+		return fmt.Errorf("verify: %w: %s %s, remote has %s", ErrChecksumMismatch, verb, want, sum)
+	}
+	return nil
+}
+
+// FileSHA256 returns the hex SHA-256 of the local file at localPath.
+func FileSHA256(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPrefix feeds the first n bytes of r to h, reporting whether there
+// were that many.
+func hashPrefix(h hash.Hash, r io.Reader, n int64) bool {
+	m, err := io.CopyN(h, r, n)
+	return err == nil && m == n
+}
+
+// partialSuffix makes the hidden name (see partialName) next to a file
+// that UploadFile, Download or FetchOnce writes to until it is complete.
+const partialSuffix = ".sshkit-partial"
+
+// partialName returns the name of the partial copy of the file name. It
+// is the same on every attempt, which is what lets the next attempt find
+// it and resume.
+func partialName(name string) string {
+	return "." + name + partialSuffix
+}
+
+// sameRemoteFile reports whether remotePath is a regular file with the
+// content of the local file localPath, which has size bytes. Sizes are
+// compared first, so files that differ in size are not hashed.
+func (c *Client) sameRemoteFile(s *sftp.Client, remotePath, localPath string, size int64) bool {
+	fi, err := s.Stat(remotePath)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() != size {
+		return false
+	}
+	local, err := FileSHA256(localPath)
+	if err != nil {
+		return false
+	}
+	remote, err := c.RemoteSHA256(remotePath)
+	return err == nil && remote == local
+}
+
+// tempName returns a hidden name next to remotePath for an upload in
+// progress, so the rename into place stays on one filesystem.
+func tempName(remotePath string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	dir, base := path.Split(remotePath)
+	return dir + "." + base + ".sshkit-" + hex.EncodeToString(b) + ".tmp", nil
+}
+
+// replace renames tmp over remotePath, with posix-rename where the server
+// offers it and remove-then-rename otherwise.
+func replace(s *sftp.Client, tmp, remotePath string) error {
+	if _, ok := s.HasExtension("posix-rename@openssh.com"); ok {
+		return s.PosixRename(tmp, remotePath)
+	}
+	if err := s.Rename(tmp, remotePath); err == nil {
+		return nil
+	}
+	_ = s.Remove(remotePath)
+	return s.Rename(tmp, remotePath)
+}
@@ -0,0 +1,195 @@
+package sshkit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+)
+
+// DefaultChunkSize is the size of the pieces a large file is split into
+// when it is sent over several SFTP channels.
+const DefaultChunkSize = 4 << 20
+
+// DefaultChannels is how many SFTP channels a large file is sent over.
+// sshd allows 10 sessions per connection (MaxSessions) by default, which
+// leaves room for the shared SFTP channel and commands running alongside.
+const DefaultChannels = 4
+
+// ParseSize parses a byte count such as 1048576, 512K or 4MiB: a number
+// with an optional K, M or G suffix, in units of 1024 whether or not
+// spelled KiB.
+func ParseSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMG"); i >= 0 && i == len(s)-1 {
+		mult = 1 << (10 * (strings.IndexByte("KMG", s[i]) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * mult, nil
+}
+
+// sizedReader is what Upload needs to know to send a reader in chunks:
+// *bytes.Reader and *strings.Reader are ones.
+type sizedReader interface {
+	io.ReaderAt
+	Size() int64
+	Len() int
+}
+
+func (c *Client) chunkSize() int64 {
+	if c.ChunkSize > 0 {
+		return c.ChunkSize
+	}
+	return DefaultChunkSize
+}
+
+func (c *Client) channels() int {
+	if c.Channels > 0 {
+		return c.Channels
+	}
+	return DefaultChannels
+}
+
+// chunkChannels returns the SFTP channels to copy n bytes over: one per
+// chunk up to Channels, or none when a single stream will do, because n
+// fits in one chunk, Channels is 1 or only one channel could be opened.
+func (c *Client) chunkChannels(n int64) []*sftp.Client {
+	want := min(int64(c.channels()), (n+c.chunkSize()-1)/c.chunkSize())
+	if want < 2 {
+		return nil
+	}
+	chans, err := c.sftpChannels(int(want))
+	if err != nil || len(chans) < 2 {
+		return nil
+	}
+	return chans
+}
+
+// sftpChannels returns up to n SFTP clients for chunked copies, each on
+// its own channel of the connection and writing a chunk's packets without
+// waiting for each to be acknowledged. They are opened on first use and
+// kept until the connection changes or Close; fewer are returned when the
+// server refuses more sessions.
+func (c *Client) sftpChannels(n int) ([]*sftp.Client, error) {
+	// SFTP reconnects first if the connection was lost.
+	if _, err := c.SFTP(); err != nil {
+		return nil, err
+	}
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+	for len(c.sftpExtra) < n {
+		s, err := sftp.NewClient(c.sftpConn, sftp.UseConcurrentWrites(true))
+		if err != nil {
+			break
+		}
+		c.sftpExtra = append(c.sftpExtra, s)
+		go func() {
+			s.Wait()
+			c.sftpMu.Lock()
+			c.sftpExtra = slices.DeleteFunc(c.sftpExtra, func(x *sftp.Client) bool { return x == s })
+			c.sftpMu.Unlock()
+		}()
+	}
+	return slices.Clone(c.sftpExtra[:min(n, len(c.sftpExtra))]), nil
+}
+
+// closeExtra closes the channels opened by sftpChannels. sftpMu is held.
+func (c *Client) closeExtra() {
+	for _, s := range c.sftpExtra {
+		s.Close()
+	}
+	c.sftpExtra = nil
+}
+
+// uploadChunks writes bytes offset to size of src to the remote file name,
+// which exists, in chunks over chans.
+func (c *Client) uploadChunks(chans []*sftp.Client, name string, src io.ReaderAt, offset, size int64, p *progress) error {
+	pairs := make([]chunkPair, 0, len(chans))
+	for _, s := range chans {
+		f, err := s.OpenFile(name, os.O_WRONLY)
+		if err != nil {
+			return fmt.Errorf("open remote: %w", err)
+		}
+		defer f.Close()
+		pairs = append(pairs, chunkPair{src, f})
+	}
+	return c.copyChunks(pairs, offset, size, p)
+}
+
+// downloadChunks is uploadChunks the other way, from the remote file name
+// into dst.
+func (c *Client) downloadChunks(chans []*sftp.Client, name string, dst io.WriterAt, offset, size int64, p *progress) error {
+	pairs := make([]chunkPair, 0, len(chans))
+	for _, s := range chans {
+		f, err := s.Open(name)
+		if err != nil {
+			return fmt.Errorf("open remote: %w", err)
+		}
+		defer f.Close()
+		pairs = append(pairs, chunkPair{f, dst})
+	}
+	return c.copyChunks(pairs, offset, size, p)
+}
+
+// chunkPair is where one worker of copyChunks reads and writes.
+type chunkPair struct {
+	r io.ReaderAt
+	w io.WriterAt
+}
+
+// copyChunks copies bytes offset to size with a worker per pair, each
+// taking the next chunk until there are none left or one has failed.
+func (c *Client) copyChunks(pairs []chunkPair, offset, size int64, p *progress) error {
+	defer p.finish()
+	chunk := c.chunkSize()
+	var (
+		next    atomic.Int64
+		failed  atomic.Bool
+		errOnce sync.Once
+		copyErr error
+		wg      sync.WaitGroup
+	)
+	for _, pair := range pairs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, min(chunk, size-offset))
+			for !failed.Load() {
+				off := offset + (next.Add(1)-1)*chunk
+				if off >= size {
+					return
+				}
+				b := buf[:min(chunk, size-off)]
+				n, err := pair.r.ReadAt(b, off)
+				if n == len(b) {
+					_, err = pair.w.WriteAt(b, off)
+				} else if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				if err != nil {
+					errOnce.Do(func() { copyErr = err })
+					failed.Store(true)
+					return
+				}
+				p.add(n)
+			}
+		}()
+	}
+	wg.Wait()
+	if copyErr != nil {
+		return fmt.Errorf("copy: %w", c.inFlight(c.lostChan(), copyErr))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package sshkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"0", 0, false},
+		{"1048576", 1 << 20, false},
+		{"512K", 512 << 10, false},
+		{"512k", 512 << 10, false},
+		{"4M", 4 << 20, false},
+		{"4MB", 4 << 20, false},
+		{"4MiB", 4 << 20, false},
+		{"4mib", 4 << 20, false},
+		{"2G", 2 << 30, false},
+		{"2GiB", 2 << 30, false},
+		{" 8 K ", 8 << 10, false},
+		{"100B", 100, false},
+		{"", 0, true},
+		{"K", 0, true},
+		{"-1", 0, true},
+		{"-1K", 0, true},
+		{"1.5M", 0, true},
+		{"4T", 0, true},
+		{"4KM", 0, true},
+		{"M4", 0, true},
+		{"four", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCopyChunks(t *testing.T) {
+	tests := []struct {
+		name        string
+		size, chunk int64
+		offset      int64
+		workers     int
+	}{
+		{"multiple of the chunk", 64, 16, 0, 3},
+		{"short last chunk", 70, 16, 0, 3},
+		{"one byte over", 17, 16, 0, 2},
+		{"smaller than a chunk", 5, 16, 0, 4},
+		{"more workers than chunks", 40, 16, 0, 8},
+		{"one worker", 70, 16, 0, 1},
+		{"resumed mid chunk", 70, 16, 21, 3},
+		{"resumed at the end", 70, 16, 70, 3},
+		{"empty", 0, 16, 0, 2},
+	}
+	for _, tt := range tests {
+		src := make([]byte, tt.size)
+		for i := range src {
+			src[i] = byte(i%251 + 1)
+		}
+		dst := &memFile{}
+		pairs := make([]chunkPair, tt.workers)
+		for i := range pairs {
+			pairs[i] = chunkPair{bytes.NewReader(src), dst}
+		}
+		c := &Client{ChunkSize: tt.chunk}
+		if err := c.copyChunks(pairs, tt.offset, tt.size, nil); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := make([]byte, tt.size)
+		copy(want[tt.offset:], src[tt.offset:])
+		if got := dst.bytes(tt.size); !bytes.Equal(got, want) {
+			t.Errorf("%s: copied bytes differ from the source", tt.name)
+		}
+		if n := int64(len(dst.b)); n > tt.size {
+			t.Errorf("%s: wrote %d bytes past the end", tt.name, n-tt.size)
+		}
+	}
+}
+
+func TestCopyChunksShortSource(t *testing.T) {
+	c := &Client{ChunkSize: 16}
+	src := strings.NewReader(strings.Repeat("x", 40))
+	pairs := []chunkPair{{src, &memFile{}}, {src, &memFile{}}}
+	err := c.copyChunks(pairs, 0, 64, nil)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("copying 64 bytes from 40: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// memFile is an in-memory io.WriterAt.
+type memFile struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(f.b)) {
+		f.b = append(f.b, make([]byte, end-int64(len(f.b)))...)
+	}
+	return copy(f.b[off:], p), nil
+}
+
+// bytes returns the first n bytes, zero where nothing was written.
+func (f *memFile) bytes(n int64) []byte {
+	out := make([]byte, n)
+	copy(out, f.b)
+	return out
+}
@@ -0,0 +1,643 @@
+package sshkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client is a connected SSH client with the exec, transfer and sudo helpers
+// shared by every program in the repository.
+//
+// A Client made by Dialer.Connect redials its target when the connection
+// has been lost (see NewSession), so the *ssh.Client underneath changes;
+// Conn returns the current one.
+type Client struct {
+	Target *Target
+
+	// UseSudo makes AsRoot wrap commands to run as root with SudoMethod
+	// (Sudo when empty), answering its prompt with SudoPassword.
+	UseSudo      bool
+	SudoMethod   SudoMethod
+	SudoPassword string
+
+	// KillGrace is how long a cancelled command gets between SIGTERM and
+	// SIGKILL; DefaultKillGrace when zero.
+	KillGrace time.Duration
+
+	// JobDir is the remote directory holding StartJob's jobs;
+	// DefaultJobDir when empty.
+	JobDir string
+
+	// Progress, when set, is where UploadFile, Download and Upload show
+	// how far each file has got: bytes, rate and ETA, redrawn in place if
+	// it is a terminal.
+	Progress io.Writer
+
+	// ChunkSize and Channels set how UploadFile, Download and Upload of a
+	// *bytes.Reader move a file larger than ChunkSize (DefaultChunkSize
+	// when zero): in chunks written concurrently over Channels SFTP
+	// channels on the connection (DefaultChannels when zero). A Channels
+	// of 1 keeps every file to a single stream.
+	ChunkSize int64
+	Channels  int
+
+	dialer  *Dialer
+	connMu  sync.Mutex
+	sshConn *ssh.Client   // replaced on reconnect; guarded by connMu
+	lost    chan struct{} // closed when sshConn goes away
+	closed  bool
+
+	sftpMu    sync.Mutex
+	sftp      *sftp.Client
+	sftpConn  *ssh.Client    // the connection sftp runs on
+	sftpExtra []*sftp.Client // channels for chunked transfers, on sftpConn
+
+	noHash     atomic.Bool // the host cannot run sha256Script
+	hashWarned atomic.Bool
+}
+
+// Connect dials t and wraps the connection in a Client.
+func (d *Dialer) Connect(t *Target) (*Client, error) {
+	c, err := d.Dial(t)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c, t, d), nil
+}
+
+// NewClient wraps a connection made elsewhere, such as by DialMux, in a
+// Client. When d is not nil, a lost connection is redialed with it as for
+// Dialer.Connect; otherwise operations fail with ErrConnectionLost.
+func NewClient(conn *ssh.Client, t *Target, d *Dialer) *Client {
+	c := &Client{Target: t, dialer: d, sshConn: conn}
+	c.watch(conn)
+	return c
+}
+
+// Connect resolves spec and dials it with DialerFromEnv.
+func Connect(spec string) (*Client, error) {
+	t, err := Resolve(spec)
+	if err != nil {
+		return nil, err
+	}
+	return DialerFromEnv().Connect(t)
+}
+
+// SFTP returns the connection's SFTP client, starting the subsystem on
+// first use. The client is shared by every caller and safe for concurrent
+// use; it is reopened if the subsystem goes away, and closed by Close.
+func (c *Client) SFTP() (*sftp.Client, error) {
+	conn, _, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+	if c.sftp != nil && c.sftpConn == conn {
+		return c.sftp, nil
+	}
+	s, err := sftp.NewClient(conn)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: %w", err)
+	}
+	c.closeExtra()
+	c.sftp, c.sftpConn = s, conn
+	go func() {
+		s.Wait()
+		c.sftpMu.Lock()
+		if c.sftp == s {
+			c.sftp = nil
+		}
+		c.sftpMu.Unlock()
+	}()
+	return s, nil
+}
+
+// Close closes the SFTP client, if one was started, and the connection.
+func (c *Client) Close() error {
+	c.connMu.Lock()
+	c.closed = true
+	conn := c.sshConn
+	c.connMu.Unlock()
+	c.sftpMu.Lock()
+	if c.sftp != nil {
+		c.sftp.Close()
+		c.sftp = nil
+	}
+	c.closeExtra()
+	c.sftpMu.Unlock()
+	return conn.Close()
+}
+
+// DefaultKillGrace is the SIGTERM to SIGKILL delay for cancelled commands.
+const DefaultKillGrace = 5 * time.Second
+
+// ErrTimeout is wrapped by the error returned when a command is stopped
+// because its context's deadline passed.
+var ErrTimeout = errors.New("remote command timed out")
+
+// Result is the outcome of a command that ran to completion.
+type Result struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// CommandError reports a command that exited non-zero. Stdout and Stderr
+// hold the captured output, or its tail for streamed commands.
+type CommandError struct {
+	Cmd      string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// errorLines is how much output CommandError.Error quotes.
+const errorLines = 10
+
+// Error leaves out Cmd, which can be long. It quotes the last lines of
+// stderr, or of stdout when stderr is empty.
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("remote command exited %d", e.ExitCode)
+	out := strings.TrimSpace(e.Stderr)
+	if out == "" {
+		out = strings.TrimSpace(e.Stdout)
+	}
+	if lines := strings.Split(out, "\n"); len(lines) > errorLines {
+		out = "...\n" + strings.Join(lines[len(lines)-errorLines:], "\n")
+	}
+	if out != "" {
+		msg += ": " + out
+	}
+	return msg
+}
+
+// Exec runs cmd and captures its output. A non-zero exit status is
+// reported in Result.ExitCode; err is only set when the command could not
+// be run to completion.
+func (c *Client) Exec(cmd string) (*Result, error) {
+	return c.ExecContext(context.Background(), cmd)
+}
+
+// ExecContext is Exec with cancellation: when ctx is done the remote
+// process is sent SIGTERM, then SIGKILL after KillGrace, and the session
+// is closed. The output captured so far is returned along with the error,
+// which wraps ErrTimeout if ctx's deadline passed and ctx.Err() otherwise.
+func (c *Client) ExecContext(ctx context.Context, cmd string) (*Result, error) {
+	return c.exec(ctx, cmd, nil, nil)
+}
+
+// ExecCommand is ExecContext for a built command. Its Env is sent as
+// session environment requests, or put on the command line with env(1)
+// when the server refuses them (sshd only accepts the names listed in its
+// AcceptEnv). stdin, when not nil, is copied to the command's standard
+// input; otherwise it reads end of file.
+func (c *Client) ExecCommand(ctx context.Context, cmd *Command, stdin io.Reader) (*Result, error) {
+	return withEnv(cmd, func(line string, env []string) (*Result, error) {
+		return c.exec(ctx, line, env, stdin)
+	})
+}
+
+func (c *Client) exec(ctx context.Context, cmd string, env []string, stdin io.Reader) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	code, err := c.run(ctx, cmd, env, stdin, &stdout, &stderr)
+	res := &Result{ExitCode: code, Stdout: stdout.String(), Stderr: stderr.String()}
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return res, err
+}
+
+// errEnvRefused is returned by run when the server rejects an environment
+// request.
+var errEnvRefused = errors.New("environment request refused")
+
+// errExecRefused is wrapped by run's error when the server rejects the
+// exec request, as SFTP-only accounts do.
+var errExecRefused = errors.New("exec request refused")
+
+// withEnv calls run with cmd's Env as session environment, and again with
+// the variables on the command line if the server refuses them.
+func withEnv(cmd *Command, run func(line string, env []string) (*Result, error)) (*Result, error) {
+	if len(cmd.Env) > 0 {
+		bare := *cmd
+		bare.Env = nil
+		res, err := run(bare.String(), cmd.Env)
+		if !errors.Is(err, errEnvRefused) {
+			return res, err
+		}
+	}
+	return run(cmd.String(), nil)
+}
+
+// Output runs cmd and returns its stdout. A non-zero exit is returned as a
+// *CommandError carrying stderr.
+func (c *Client) Output(cmd string) (string, error) {
+	return c.OutputContext(context.Background(), cmd)
+}
+
+// OutputContext is Output with the cancellation of ExecContext.
+func (c *Client) OutputContext(ctx context.Context, cmd string) (string, error) {
+	res, err := c.ExecContext(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if res.ExitCode != 0 {
+		return res.Stdout, &CommandError{Cmd: cmd, ExitCode: res.ExitCode, Stdout: res.Stdout, Stderr: res.Stderr}
+	}
+	return res.Stdout, nil
+}
+
+// Run runs cmd with its output streamed to stdout and stderr. A non-zero
+// exit is returned as a *CommandError carrying the tail of the output.
+func (c *Client) Run(cmd string, stdout, stderr io.Writer) error {
+	return c.RunContext(context.Background(), cmd, stdout, stderr)
+}
+
+// RunContext is Run with the cancellation of ExecContext.
+func (c *Client) RunContext(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	res, err := c.StreamContext(ctx, cmd, stdout, stderr, DefaultTail)
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return &CommandError{Cmd: cmd, ExitCode: res.ExitCode, Stdout: res.Stdout, Stderr: res.Stderr}
+	}
+	return nil
+}
+
+// run executes cmd in a new session with env set and returns its exit
+// status, stopping the remote process if ctx is done first. Without stdin,
+// password prompts from AsRoot wrappers in cmd are answered.
+func (c *Client) run(ctx context.Context, cmd string, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	session, lost, err := c.newSession()
+	if err != nil {
+		return -1, fmt.Errorf("new session: %w", err)
+	}
+	defer session.Close()
+
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if err := session.Setenv(k, v); err != nil {
+			return -1, errEnvRefused
+		}
+	}
+
+	flush := func() {}
+	if stdin == nil {
+		if stdout, stderr, flush, err = c.answerPrompts(session, cmd, stdout, stderr); err != nil {
+			return -1, err
+		}
+	} else {
+		session.Stdin = stdin
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if err := session.Start(cmd); err != nil {
+		if !isDone(lost) {
+			// The connection is up, so the server turned the exec down;
+			// ssh's error would quote all of cmd.
+			err = errExecRefused
+		}
+		return -1, fmt.Errorf("start: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	defer flush()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		c.stop(session, done)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return -1, ErrTimeout
+		}
+		return -1, fmt.Errorf("remote command: %w", ctx.Err())
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		// Typically the connection dropping, which leaves the session
+		// without an exit status.
+		return -1, fmt.Errorf("run: %w", c.inFlight(lost, err))
+	}
+}
+
+// stop terminates the command running in session: SIGTERM, SIGKILL once
+// KillGrace has passed, then closing the channel in case the server
+// ignores signals. done receives the session's Wait result.
+func (c *Client) stop(session *ssh.Session, done <-chan error) {
+	grace := c.KillGrace
+	if grace <= 0 {
+		grace = DefaultKillGrace
+	}
+	_ = session.Signal(ssh.SIGTERM)
+	select {
+	case <-done:
+		return
+	case <-time.After(grace):
+	}
+	_ = session.Signal(ssh.SIGKILL)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+	session.Close()
+}
+
+// Upload writes r to remotePath over SFTP with the given permissions,
+// creating the parent directory if needed. The data goes to a temporary
+// name in the same directory, is hashed on the remote host and compared
+// with what was sent, and only then renamed into place, so remotePath
+// never holds a partial file. A difference is an error wrapping
+// ErrChecksumMismatch, and the temporary file is removed. On a host that
+// cannot hash it, such as an SFTP-only account, the file is put in place
+// unverified with a logged warning.
+func (c *Client) Upload(r io.Reader, remotePath string, mode os.FileMode) error {
+	s, err := c.SFTP()
+	if err != nil {
+		return err
+	}
+
+	_ = s.MkdirAll(path.Dir(remotePath))
+
+	tmp, err := tempName(remotePath)
+	if err != nil {
+		return err
+	}
+	dst, err := s.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create remote: %w", err)
+	}
+	var ra io.ReaderAt
+	size := int64(-1)
+	if sr, ok := r.(sizedReader); ok {
+		size = int64(sr.Len())
+		ra = io.NewSectionReader(sr, sr.Size()-size, size)
+	}
+	h := sha256.New()
+	p := c.newProgress(path.Base(remotePath), 0, size)
+	err = c.send(dst, r, ra, 0, size, h, p)
+	if err == nil {
+		err = c.commit(s, tmp, h, remotePath, mode)
+	}
+	if err != nil {
+		_ = s.Remove(tmp)
+	}
+	return err
+}
+
+// send copies the bytes of r from offset to size (-1 when unknown) to
+// dst, a remote temporary file open for writing at offset, hashing them
+// into h, and closes dst. ra, when not nil, reads the same bytes by
+// position, which lets a large file go in chunks over several channels.
+func (c *Client) send(dst *sftp.File, r io.Reader, ra io.ReaderAt, offset, size int64, h hash.Hash, p *progress) error {
+	if ra != nil {
+		if chans := c.chunkChannels(size - offset); len(chans) > 1 {
+			// The chunks are written through handles of their own.
+			if err := dst.Close(); err != nil {
+				return fmt.Errorf("close remote: %w", err)
+			}
+			if err := c.uploadChunks(chans, dst.Name(), ra, offset, size, p); err != nil {
+				return err
+			}
+			if _, err := io.Copy(h, io.NewSectionReader(ra, offset, size-offset)); err != nil {
+				return fmt.Errorf("read local: %w", err)
+			}
+			return nil
+		}
+	}
+	_, err := dst.ReadFrom(p.reader(io.TeeReader(r, h)))
+	p.finish()
+	cerr := dst.Close()
+	if err != nil {
+		return fmt.Errorf("copy: %w", c.inFlight(c.lostChan(), err))
+	}
+	if cerr != nil {
+		return fmt.Errorf("close remote: %w", cerr)
+	}
+	return nil
+}
+
+// commit checks that the remote temporary file tmp hashes to h, then sets
+// its permissions and renames it over remotePath.
+func (c *Client) commit(s *sftp.Client, tmp string, h hash.Hash, remotePath string, mode os.FileMode) error {
+	if err := c.verifyRemote(tmp, hex.EncodeToString(h.Sum(nil)), "sent"); err != nil {
+		return err
+	}
+	if err := s.Chmod(tmp, mode.Perm()); err != nil {
+		return fmt.Errorf("chmod remote: %w", err)
+	}
+	if err := replace(s, tmp, remotePath); err != nil {
+		return fmt.Errorf("rename into place: %w", err)
+	}
+	return nil
+}
+
+// UploadFile sends the local file at localPath to remotePath like Upload,
+// unless remotePath already has the same content, in which case only its
+// permissions are set. The temporary file has a fixed name, so when an
+// earlier attempt was interrupted the upload resumes where it stopped,
+// once the SHA-256 of what is there matches the start of the local file.
+func (c *Client) UploadFile(localPath, remotePath string, mode os.FileMode) error {
+	_, err := c.uploadFile(localPath, remotePath, mode)
+	return err
+}
+
+// uploadFile is UploadFile that also reports whether the upload was
+// skipped because the content was already there.
+func (c *Client) uploadFile(localPath, remotePath string, mode os.FileMode) (skipped bool, err error) {
+	s, err := c.SFTP()
+	if err != nil {
+		return false, err
+	}
+	src, err := os.Open(localPath)
+	if err != nil {
+		return false, fmt.Errorf("open local: %w", err)
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return false, fmt.Errorf("open local: %w", err)
+	}
+	if c.sameRemoteFile(s, remotePath, localPath, fi.Size()) {
+		if err := s.Chmod(remotePath, mode.Perm()); err != nil {
+			return true, fmt.Errorf("chmod remote: %w", err)
+		}
+		return true, nil
+	}
+
+	_ = s.MkdirAll(path.Dir(remotePath))
+
+	partial := path.Join(path.Dir(remotePath), partialName(path.Base(remotePath)))
+	h := sha256.New()
+	offset := c.uploadResumeOffset(s, partial, src, fi.Size(), h)
+	var dst *sftp.File
+	if offset > 0 {
+		if dst, err = s.OpenFile(partial, os.O_WRONLY); err == nil {
+			_, err = dst.Seek(offset, io.SeekStart)
+		}
+	} else {
+		dst, err = s.Create(partial)
+	}
+	if err != nil {
+		return false, fmt.Errorf("create remote: %w", err)
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		dst.Close()
+		return false, fmt.Errorf("read local: %w", err)
+	}
+	p := c.newProgress(path.Base(remotePath), offset, fi.Size())
+	err = c.send(dst, src, src, offset, fi.Size(), h, p)
+	if err == nil {
+		err = c.commit(s, partial, h, remotePath, mode)
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		_ = s.Remove(partial) // start over next time
+	}
+	return false, err
+}
+
+// uploadResumeOffset returns how much of the partial upload at partial
+// can be kept, having hashed that much of the local file src into h.
+func (c *Client) uploadResumeOffset(s *sftp.Client, partial string, src io.ReaderAt, size int64, h hash.Hash) int64 {
+	fi, err := s.Stat(partial)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 || fi.Size() > size {
+		return 0
+	}
+	return c.resumeOffset(src, fi.Size(), h, func(m int64) (string, error) {
+		return c.remoteSHA256(partial, m)
+	})
+}
+
+// Download copies remotePath to localPath over SFTP. Like UploadFile, it
+// writes to a hidden partial file next to localPath, resuming one left by
+// an interrupted attempt when it matches the start of the remote file,
+// and checks the SHA-256 of the result against the remote file's before
+// renaming it into place. On a mismatch the partial file is removed and
+// the error wraps ErrChecksumMismatch. As with Upload, a host that cannot
+// hash the file gets a logged warning instead of the check.
+func (c *Client) Download(remotePath, localPath string) error {
+	s, err := c.SFTP()
+	if err != nil {
+		return err
+	}
+
+	src, err := s.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("open remote: %w", err)
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("open remote: %w", err)
+	}
+
+	partial := filepath.Join(filepath.Dir(localPath), partialName(filepath.Base(localPath)))
+	h := sha256.New()
+	offset := c.downloadResumeOffset(remotePath, partial, fi.Size(), h)
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_RDWR
+	}
+	dst, err := os.OpenFile(partial, flags, 0o666)
+	if err != nil {
+		return fmt.Errorf("create local: %w", err)
+	}
+	defer dst.Close()
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("create local: %w", err)
+	}
+
+	p := c.newProgress(path.Base(remotePath), offset, fi.Size())
+	if chans := c.chunkChannels(fi.Size() - offset); len(chans) > 1 {
+		if err := c.downloadChunks(chans, remotePath, dst, offset, fi.Size(), p); err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(dst, offset, fi.Size()-offset)); err != nil {
+			return fmt.Errorf("read local: %w", err)
+		}
+	} else {
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("open remote: %w", err)
+		}
+		_, err = src.WriteTo(p.writer(io.MultiWriter(dst, h)))
+		p.finish()
+		if err != nil {
+			return fmt.Errorf("copy: %w", c.inFlight(c.lostChan(), err))
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := c.verifyRemote(remotePath, hex.EncodeToString(h.Sum(nil)), "received"); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			_ = os.Remove(partial)
+		}
+		return err
+	}
+	return os.Rename(partial, localPath)
+}
+
+// downloadResumeOffset returns how much of the local partial file can be
+// kept, having hashed it into h.
+func (c *Client) downloadResumeOffset(remotePath, partial string, size int64, h hash.Hash) int64 {
+	f, err := os.Open(partial)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 || fi.Size() > size {
+		return 0
+	}
+	return c.resumeOffset(f, fi.Size(), h, func(m int64) (string, error) {
+		return c.remoteSHA256(remotePath, m)
+	})
+}
+
+// resumeOffset returns how many leading bytes of a partial copy of n bytes
+// can be kept, hashing them from local into h: all n, or else all but the
+// chunks a chunked transfer may have left unfinished, whichever has the
+// same SHA-256 as remoteSum gives for that many bytes of the remote file;
+// 0 when neither does. Of the local and the remote file, one is the
+// partial copy and the other its source.
+func (c *Client) resumeOffset(local io.ReaderAt, n int64, h hash.Hash, remoteSum func(n int64) (string, error)) int64 {
+	candidates := []int64{n}
+	if m := n/c.chunkSize()*c.chunkSize() - int64(c.channels())*c.chunkSize(); m > 0 {
+		candidates = append(candidates, m)
+	}
+	for _, m := range candidates {
+		h.Reset()
+		if !hashPrefix(h, io.NewSectionReader(local, 0, m), m) {
+			continue
+		}
+		if sum, err := remoteSum(m); err == nil && sum == hex.EncodeToString(h.Sum(nil)) {
+			return m
+		}
+	}
+	h.Reset()
+	return 0
+}
@@ -0,0 +1,102 @@
+package sshkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartialName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"app.tar.gz", ".app.tar.gz.sshkit-partial"},
+		{".env", "..env.sshkit-partial"},
+		{"my file", ".my file.sshkit-partial"},
+	}
+	for _, tt := range tests {
+		if got := partialName(tt.in); got != tt.want {
+			t.Errorf("partialName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResumeOffset(t *testing.T) {
+	tests := []struct {
+		name          string
+		local, remote string
+		want          int64
+	}{
+		{"whole partial matches", "0123456789", "0123456789abcdef", 10},
+		{"size matches, content does not", "0123456789", "0123456780", 0},
+		{"differs in the last chunks", "0123456789abcdeXXXXX", "0123456789abcdefghij", 12},
+		{"differs in the first chunk", "X123456789abcdefghij", "0123456789abcdefghij", 0},
+		{"remote shorter than the partial", "0123456789", "01234", 0},
+	}
+	for _, tt := range tests {
+		// 4-byte chunks over 2 channels: a 20-byte partial falls back to 12.
+		c := &Client{ChunkSize: 4, Channels: 2}
+		remoteSum := func(n int64) (string, error) {
+			if n > int64(len(tt.remote)) {
+				return "", errors.New("short file")
+			}
+			return sha256Hex(tt.remote[:n]), nil
+		}
+		h := sha256.New()
+		got := c.resumeOffset(strings.NewReader(tt.local), int64(len(tt.local)), h, remoteSum)
+		if got != tt.want {
+			t.Errorf("%s: offset %d, want %d", tt.name, got, tt.want)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != sha256Hex(tt.local[:got]) {
+			t.Errorf("%s: hash holds something other than the %d kept bytes", tt.name, got)
+		}
+	}
+}
+
+func TestResumeOffsetNoRemoteHash(t *testing.T) {
+	c := &Client{}
+	remoteSum := func(int64) (string, error) { return "", errNoRemoteHash }
+	if got := c.resumeOffset(strings.NewReader("0123"), 4, sha256.New(), remoteSum); got != 0 {
+		t.Errorf("offset %d without a remote hash, want 0", got)
+	}
+}
+
+// TestDownloadResumeOffset covers the partial files that are started over
+// without asking the remote host.
+func TestDownloadResumeOffset(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	tests := []struct {
+		name    string
+		partial string
+		size    int64
+	}{
+		{"missing", filepath.Join(dir, "missing"), 10},
+		{"larger than the source", write("larger", "0123456789"), 5},
+		{"empty", write("empty", ""), 10},
+		{"a directory", dir, 10},
+	}
+	for _, tt := range tests {
+		c := &Client{}
+		c.noHash.Store(true) // there is no host to ask
+		h := sha256.New()
+		if got := c.downloadResumeOffset("/remote/file", tt.partial, tt.size, h); got != 0 {
+			t.Errorf("%s: offset %d, want 0", tt.name, got)
+		}
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
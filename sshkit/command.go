@@ -0,0 +1,88 @@
+package sshkit
+
+import "strings"
+
+// Command is a remote command line assembled from parts that are quoted
+// when it is rendered, so paths and arguments taken from users, flags or
+// files reach the program as single words and cannot inject shell syntax.
+//
+//	sshkit.NewCommand("tar", "-xzf", tarball).In(dir).String()
+//	// cd /tmp/build && tar -xzf 'my file.tar.gz'
+type Command struct {
+	Program string
+	Args    []string
+	Env     []string // KEY=value pairs, passed through env(1)
+	Dir     string   // working directory; empty for the login directory
+}
+
+// NewCommand returns the command running program with args.
+func NewCommand(program string, args ...string) *Command {
+	return &Command{Program: program, Args: args}
+}
+
+// Script returns the command running script with sh -c. The script is
+// shell text and must be fixed by the caller; values go in args, where
+// the script reads them as "$1", "$2" and so on:
+//
+//	sshkit.Script(`ls "$1"/key_*.json 2>/dev/null || true`, dir)
+func Script(script string, args ...string) *Command {
+	return NewCommand("sh", append([]string{"-c", script, "sh"}, args...)...)
+}
+
+// In sets the working directory and returns c.
+func (c *Command) In(dir string) *Command {
+	c.Dir = dir
+	return c
+}
+
+// Setenv adds an environment variable and returns c.
+func (c *Command) Setenv(key, value string) *Command {
+	c.Env = append(c.Env, key+"="+value)
+	return c
+}
+
+// String renders the command line for the remote shell.
+func (c *Command) String() string {
+	var b strings.Builder
+	if c.Dir != "" {
+		b.WriteString("cd " + Quote(c.Dir) + " && ")
+	}
+	if len(c.Env) > 0 {
+		b.WriteString("env")
+		for _, kv := range c.Env {
+			b.WriteString(" " + Quote(kv))
+		}
+		b.WriteString(" ")
+	}
+	if strings.Contains(c.Program, "=") {
+		// Unquoted, the shell would take it for an assignment.
+		b.WriteString("'" + strings.ReplaceAll(c.Program, "'", `'\''`) + "'")
+	} else {
+		b.WriteString(Quote(c.Program))
+	}
+	for _, a := range c.Args {
+		b.WriteString(" " + Quote(a))
+	}
+	return b.String()
+}
+
+// Quote returns s quoted as a single word for a POSIX shell: unchanged
+// when it only holds characters the shell treats literally, single-quoted
+// otherwise.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return !isShellSafe(r) }) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func isShellSafe(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("@%+=:,./_-", r)
+}
@@ -0,0 +1,87 @@
+package sshkit
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"/tmp/a-b_c.d", "/tmp/a-b_c.d"},
+		{"user@host:22", "user@host:22"},
+		{"KEY=v,w+%", "KEY=v,w+%"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"a;rm -rf /", "'a;rm -rf /'"},
+		{"`id`", "'`id`'"},
+		{"*", "'*'"},
+		{"~", "'~'"},
+		{"line\nbreak", "'line\nbreak'"},
+		{"ünï", "'ünï'"},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  *Command
+		want string
+	}{
+		{"program only", NewCommand("uptime"), "uptime"},
+		{"args", NewCommand("tar", "-xzf", "my file.tar.gz"), "tar -xzf 'my file.tar.gz'"},
+		{"empty arg", NewCommand("printf", ""), "printf ''"},
+		{"dir", NewCommand("ls").In("/srv/my app"), "cd '/srv/my app' && ls"},
+		{"env", NewCommand("make").Setenv("CC", "gcc -O2"), "env 'CC=gcc -O2' make"},
+		{"dir and env", NewCommand("make", "all").In("/src").Setenv("V", "1"), "cd /src && env V=1 make all"},
+		{"program with =", NewCommand("A=b"), "'A=b'"},
+		{"program with space", NewCommand("/opt/my tool"), "'/opt/my tool'"},
+		{"script", Script(`ls "$1"`, "/tmp/x y"), `sh -c 'ls "$1"' sh '/tmp/x y'`},
+		{"script without args", Script("true"), "sh -c true sh"},
+	}
+	for _, tt := range tests {
+		if got := tt.cmd.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestCommandShell runs rendered commands through a local sh to check that
+// every argument arrives as one word, unchanged.
+func TestCommandShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	args := []string{"", "plain", "two words", "it's", "$HOME", "`id`", "a;b|c&d", "*", "~", "line\nbreak", `back\slash`, "-n"}
+	tests := []struct {
+		name string
+		cmd  *Command
+	}{
+		{"printf", NewCommand("printf", append([]string{`[%s]\n`}, args...)...)},
+		{"script", Script(`for a; do printf '[%s]\n' "$a"; done`, args...)},
+	}
+	var want strings.Builder
+	for _, a := range args {
+		want.WriteString("[" + a + "]\n")
+	}
+	for _, tt := range tests {
+		out, err := exec.Command(sh, "-c", tt.cmd.String()).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(out) != want.String() {
+			t.Errorf("%s: got %q, want %q", tt.name, out, want.String())
+		}
+	}
+}
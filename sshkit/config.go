@@ -0,0 +1,254 @@
+package sshkit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target is a connection destination resolved through ~/.ssh/config.
+type Target struct {
+	Alias               string // the name the user typed
+	User                string
+	HostName            string
+	Port                string
+	IdentityFiles       []string
+	ProxyJump           string // comma-separated [user@]host[:port] hops, "" for none
+	ConnectTimeout      time.Duration
+	ServerAliveInterval time.Duration // 0 for the Dialer's KeepAlive, negative for none
+	ServerAliveCountMax int
+	Reconnect           int // redials of a lost connection; 0 for the Dialer's, negative for none
+}
+
+// Addr returns the host:port to dial.
+func (t *Target) Addr() string {
+	return net.JoinHostPort(t.HostName, t.Port)
+}
+
+func (t *Target) String() string {
+	return t.User + "@" + t.Addr()
+}
+
+// Resolve turns "[user@]host[:port]" into a Target. host may be an alias
+// from the ssh config file ($SSH_CONFIG, default ~/.ssh/config); explicit
+// user and port in spec win over the config, as they do for ssh.
+func Resolve(spec string) (*Target, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("empty host")
+	}
+	var specUser, specPort string
+	host := spec
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		specUser, host = host[:i], host[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, specPort = h, p
+	}
+
+	cfgPath := os.Getenv("SSH_CONFIG")
+	if cfgPath == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			cfgPath = filepath.Join(home, ".ssh", "config")
+		}
+	}
+	opts := map[string][]string{}
+	if cfgPath != "" {
+		if err := readSSHConfig(expandHome(cfgPath), host, opts, 0); err != nil {
+			return nil, err
+		}
+	}
+	first := func(k string) string {
+		if v := opts[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	t := &Target{Alias: host, HostName: host, Port: "22", ServerAliveCountMax: 3}
+	if v := first("hostname"); v != "" {
+		t.HostName = strings.ReplaceAll(v, "%h", host)
+	}
+	if v := first("port"); v != "" {
+		t.Port = v
+	}
+	if specPort != "" {
+		t.Port = specPort
+	}
+	t.User = first("user")
+	if specUser != "" {
+		t.User = specUser
+	}
+	if t.User == "" {
+		if u, err := user.Current(); err == nil {
+			t.User = u.Username
+		}
+	}
+	if v := first("proxyjump"); v != "" && !strings.EqualFold(v, "none") {
+		t.ProxyJump = v
+	}
+	var err error
+	if t.ConnectTimeout, err = seconds(first("connecttimeout")); err != nil {
+		return nil, fmt.Errorf("%s: ConnectTimeout: %w", host, err)
+	}
+	if t.ServerAliveInterval, err = seconds(first("serveraliveinterval")); err != nil {
+		return nil, fmt.Errorf("%s: ServerAliveInterval: %w", host, err)
+	}
+	if first("serveraliveinterval") == "0" {
+		t.ServerAliveInterval = -1 // turned off, as for ssh
+	}
+	if v := first("serveralivecountmax"); v != "" {
+		if t.ServerAliveCountMax, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%s: ServerAliveCountMax: %w", host, err)
+		}
+	}
+	for _, f := range opts["identityfile"] {
+		if !strings.EqualFold(f, "none") {
+			t.IdentityFiles = append(t.IdentityFiles, t.expandTokens(f))
+		}
+	}
+	return t, nil
+}
+
+// expandTokens expands ~ and the %d %h %r %u %% tokens in an IdentityFile.
+func (t *Target) expandTokens(s string) string {
+	home, _ := os.UserHomeDir()
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	s = strings.NewReplacer("%%", "%", "%d", home, "%h", t.HostName, "%r", t.User, "%u", local).Replace(s)
+	return expandHome(s)
+}
+
+// readSSHConfig collects the options that apply to host from file into
+// opts, keyed by lower-case keyword. The first value obtained wins except
+// for IdentityFile, which accumulates. Match blocks are skipped.
+func readSSHConfig(file, host string, opts map[string][]string, depth int) error {
+	if depth > 8 {
+		return fmt.Errorf("ssh config: Include nested too deeply at %s", file)
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ssh config: %w", err)
+	}
+	defer f.Close()
+
+	active := true
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		key, args := splitConfigLine(sc.Text())
+		if key == "" {
+			continue
+		}
+		switch key {
+		case "host":
+			active = matchHost(host, args)
+			continue
+		case "match":
+			active = false
+			continue
+		case "include":
+			if !active {
+				continue
+			}
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(file), pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, m := range matches {
+					if err := readSSHConfig(m, host, opts, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if !active || len(args) == 0 {
+			continue
+		}
+		if key == "identityfile" || len(opts[key]) == 0 {
+			opts[key] = append(opts[key], args[0])
+		}
+	}
+	return sc.Err()
+}
+
+// splitConfigLine returns the lower-cased keyword and its arguments,
+// accepting both "Key value" and "Key=value" and double-quoted arguments.
+func splitConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:i])
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	var cur strings.Builder
+	inQuote, have := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote, have = !inQuote, true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if have {
+				args = append(args, cur.String())
+				cur.Reset()
+				have = false
+			}
+		default:
+			cur.WriteRune(r)
+			have = true
+		}
+	}
+	if have {
+		args = append(args, cur.String())
+	}
+	return key, args
+}
+
+// matchHost applies ssh_config Host patterns: any positive match selects
+// the block unless a negated pattern also matches.
+func matchHost(host string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		neg := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(host)); ok {
+			if neg {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// seconds parses an ssh_config time value in seconds ("" is zero).
+func seconds(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * time.Second, nil
+}
@@ -0,0 +1,150 @@
+package sshkit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSSHConfig = `# comment
+Include extra.conf
+
+Host web
+    HostName %h.example.com
+    Port 2222
+    User deploy
+    IdentityFile ~/.ssh/web_ed25519
+    ProxyJump bastion
+    ConnectTimeout 10
+
+Host db db-*
+    HostName=10.0.0.21
+    User "db admin"
+    ServerAliveInterval 0
+
+Host nojump
+    ProxyJump none
+
+Host !skipped *.internal
+    ServerAliveInterval 15
+    ServerAliveCountMax 5
+
+Match user root
+    User nobody
+    Include extra.conf
+
+Host *
+    User fallback
+    IdentityFile /keys/%r@%h
+    Port 2200
+`
+
+const testSSHConfigExtra = `Host included
+    HostName 192.0.2.7
+`
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(testSSHConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra.conf"), []byte(testSSHConfigExtra), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_CONFIG", filepath.Join(dir, "config"))
+	t.Setenv("HOME", dir)
+
+	tests := []struct {
+		spec string
+		want Target
+	}{
+		{"web", Target{
+			Alias: "web", User: "deploy", HostName: "web.example.com", Port: "2222",
+			IdentityFiles:  []string{filepath.Join(dir, ".ssh/web_ed25519"), "/keys/deploy@web.example.com"},
+			ProxyJump:      "bastion",
+			ConnectTimeout: 10 * time.Second, ServerAliveCountMax: 3,
+		}},
+		{"root@web:22", Target{
+			Alias: "web", User: "root", HostName: "web.example.com", Port: "22",
+			IdentityFiles:  []string{filepath.Join(dir, ".ssh/web_ed25519"), "/keys/root@web.example.com"},
+			ProxyJump:      "bastion",
+			ConnectTimeout: 10 * time.Second, ServerAliveCountMax: 3,
+		}},
+		{"db-2", Target{
+			Alias: "db-2", User: "db admin", HostName: "10.0.0.21", Port: "2200",
+			IdentityFiles:       []string{"/keys/db admin@10.0.0.21"},
+			ServerAliveInterval: -1, ServerAliveCountMax: 3,
+		}},
+		{"nojump", Target{
+			Alias: "nojump", User: "fallback", HostName: "nojump", Port: "2200",
+			IdentityFiles: []string{"/keys/fallback@nojump"}, ServerAliveCountMax: 3,
+		}},
+		{"app.internal", Target{
+			Alias: "app.internal", User: "fallback", HostName: "app.internal", Port: "2200",
+			IdentityFiles:       []string{"/keys/fallback@app.internal"},
+			ServerAliveInterval: 15 * time.Second, ServerAliveCountMax: 5,
+		}},
+		{"skipped", Target{
+			Alias: "skipped", User: "fallback", HostName: "skipped", Port: "2200",
+			IdentityFiles: []string{"/keys/fallback@skipped"}, ServerAliveCountMax: 3,
+		}},
+		{"u@included", Target{
+			Alias: "included", User: "u", HostName: "192.0.2.7", Port: "2200",
+			IdentityFiles: []string{"/keys/u@192.0.2.7"}, ServerAliveCountMax: 3,
+		}},
+		{"u@[2001:db8::1]:2022", Target{
+			Alias: "2001:db8::1", User: "u", HostName: "2001:db8::1", Port: "2022",
+			IdentityFiles: []string{"/keys/u@2001:db8::1"}, ServerAliveCountMax: 3,
+		}},
+	}
+	for _, tt := range tests {
+		got, err := Resolve(tt.spec)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Resolve(%q) =\n  %+v\nwant\n  %+v", tt.spec, *got, tt.want)
+		}
+	}
+
+	if _, err := Resolve(""); err == nil {
+		t.Error("Resolve(\"\") succeeded")
+	}
+}
+
+func TestResolveBadValue(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(cfg, []byte("Host *\n  ConnectTimeout soon\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_CONFIG", cfg)
+	if _, err := Resolve("u@host"); err == nil {
+		t.Error("Resolve accepted ConnectTimeout soon")
+	}
+}
+
+func TestSplitConfigLine(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+		args []string
+	}{
+		{"", "", nil},
+		{"   # comment", "", nil},
+		{"HostName example.com", "hostname", []string{"example.com"}},
+		{"Port=2222", "port", []string{"2222"}},
+		{"  Port = 2222", "port", []string{"2222"}},
+		{"Host a b\tc", "host", []string{"a", "b", "c"}},
+		{`IdentityFile "/path/with space/key"`, "identityfile", []string{"/path/with space/key"}},
+		{"Compression", "compression", nil},
+	}
+	for _, tt := range tests {
+		key, args := splitConfigLine(tt.line)
+		if key != tt.key || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitConfigLine(%q) = %q, %q; want %q, %q", tt.line, key, args, tt.key, tt.args)
+		}
+	}
+}
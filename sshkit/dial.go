@@ -0,0 +1,346 @@
+package sshkit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxHops bounds jump host chains, including ProxyJump entries of the
+// jump hosts themselves, so a config loop cannot recurse forever.
+const maxHops = 8
+
+// Dialer connects to resolved targets with a shared auth and host key policy.
+type Dialer struct {
+	Auth     *Auth
+	HostKeys *KnownHosts
+	// Timeout overrides the target's ConnectTimeout when non-zero.
+	Timeout time.Duration
+	// Jump is a comma-separated list of [user@]host[:port] jump hosts that
+	// overrides the target's ProxyJump. Each hop is resolved through
+	// ResolveJump, so it gets its own User, Port and IdentityFile.
+	Jump string
+	// HopAuth replaces Auth for the hosts it names (by Target.Alias), for
+	// jump hosts that need different credentials than the final target.
+	HopAuth map[string]*Auth
+	// JumpAuth replaces Auth for the jump hosts HopAuth does not name,
+	// whether they come from Jump or from a ProxyJump in the ssh config.
+	// DialerFromEnv sets it from SSH_JUMP_KEY.
+	JumpAuth *Auth
+	// ResolveJump resolves each jump host spec, Resolve when nil.
+	// Host.Dialer sets it so that jump hosts may be inventory hosts.
+	ResolveJump func(spec string) (*Target, error)
+	// KeepAlive is the keepalive interval for targets without a
+	// ServerAliveInterval: DefaultKeepAlive when zero, none when negative.
+	KeepAlive time.Duration
+	// Reconnect is how many times clients redial a lost connection, for
+	// targets that do not set it: DefaultReconnect when zero, none when
+	// negative.
+	Reconnect int
+}
+
+// DefaultKeepAlive is the keepalive interval when neither the ssh config
+// nor the Dialer sets one.
+const DefaultKeepAlive = 30 * time.Second
+
+// DialerFromEnv returns a Dialer using AuthFromEnv and KnownHostsFromEnv,
+// with jump hosts from SSH_JUMP, keys for every jump host (from SSH_JUMP
+// or ProxyJump) from SSH_JUMP_KEY (paths separated as for SSH_KEY), the
+// keepalive interval from SSH_KEEPALIVE (30s, 30 or off) and reconnect
+// attempts from SSH_RECONNECT (0 for none). Malformed values are reported
+// and ignored.
+func DialerFromEnv() *Dialer {
+	d := &Dialer{Auth: AuthFromEnv(), HostKeys: KnownHostsFromEnv(), Jump: os.Getenv("SSH_JUMP")}
+	if k := os.Getenv("SSH_JUMP_KEY"); k != "" {
+		var keys []string
+		for _, p := range filepath.SplitList(k) {
+			keys = append(keys, expandHome(p))
+		}
+		d = d.WithJumpKeys(keys)
+	}
+	var err error
+	if v := os.Getenv("SSH_KEEPALIVE"); v != "" {
+		if d.KeepAlive, err = ParseKeepAlive(v); err != nil {
+			log.Printf("SSH_KEEPALIVE: %v", err)
+		}
+	}
+	if v := os.Getenv("SSH_RECONNECT"); v != "" {
+		if d.Reconnect, err = ParseReconnect(v); err != nil {
+			log.Printf("SSH_RECONNECT: %v", err)
+		}
+	}
+	return d
+}
+
+// WithJumpKeys returns a copy of d whose JumpAuth offers keys to the jump
+// hosts, in place of Auth's key files and ahead of its other methods if
+// key auth is not among them.
+func (d *Dialer) WithJumpKeys(keys []string) *Dialer {
+	nd := *d
+	a := *d.Auth
+	a.KeyFiles = keys
+	if !slices.Contains(a.Order, AuthKey) {
+		a.Order = append([]string{AuthKey}, a.Order...)
+	}
+	nd.JumpAuth = &a
+	return &nd
+}
+
+// ParseKeepAlive reads a keepalive interval: a duration (15s), seconds
+// (15), or 0, off or no for none, which is returned as -1.
+func ParseKeepAlive(v string) (time.Duration, error) {
+	s := strings.ToLower(strings.TrimSpace(v))
+	switch s {
+	case "off", "no", "none":
+		return -1, nil
+	}
+	d, err := time.ParseDuration(s)
+	if n, nerr := strconv.Atoi(s); nerr == nil {
+		d, err = time.Duration(n)*time.Second, nil
+	}
+	switch {
+	case err != nil || d < 0:
+		return 0, fmt.Errorf("invalid keepalive %q (want 30s, 30 or off)", v)
+	case d == 0:
+		return -1, nil
+	}
+	return d, nil
+}
+
+// ParseReconnect reads a reconnect attempt count; 0 (none) is returned
+// as -1.
+func ParseReconnect(v string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid reconnect count %q", v)
+	}
+	if n == 0 {
+		return -1, nil
+	}
+	return n, nil
+}
+
+// Config builds the ssh.ClientConfig for t. IdentityFile entries from the
+// ssh config are offered after any explicitly configured keys.
+func (d *Dialer) Config(t *Target) (*ssh.ClientConfig, error) {
+	return d.config(t, false, nil)
+}
+
+// config is Config for t, a jump host if hop is set, adding the ssh-agent
+// connections the handshake opens to agents.
+func (d *Dialer) config(t *Target, hop bool, agents *connSet) (*ssh.ClientConfig, error) {
+	auth := d.authFor(t, hop)
+	auth.KeyFiles = append([]string(nil), auth.KeyFiles...)
+	for _, f := range t.IdentityFiles {
+		if _, err := os.Stat(f); err == nil && !slices.Contains(auth.KeyFiles, f) {
+			auth.KeyFiles = append(auth.KeyFiles, f)
+		}
+	}
+	methods, err := auth.methods(agents)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ssh.ClientConfig{
+		User:    t.User,
+		Auth:    methods,
+		Timeout: t.ConnectTimeout,
+	}
+	if d.Timeout > 0 {
+		cfg.Timeout = d.Timeout
+	}
+	if err := d.HostKeys.Apply(cfg, t.Addr()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// authFor returns the Auth for t, a jump host if hop is set: from HopAuth,
+// else JumpAuth for a jump host, else Auth.
+func (d *Dialer) authFor(t *Target, hop bool) Auth {
+	if a, ok := d.HopAuth[t.Alias]; ok {
+		return *a
+	}
+	if hop && d.JumpAuth != nil {
+		return *d.JumpAuth
+	}
+	return *d.Auth
+}
+
+func (d *Dialer) resolveJump(spec string) (*Target, error) {
+	if d.ResolveJump != nil {
+		return d.ResolveJump(spec)
+	}
+	return Resolve(spec)
+}
+
+// Dial connects to t, through its jump hosts if any, and starts
+// keepalives on every hop: each hop's ServerAliveInterval, or KeepAlive.
+// Closing the returned client tears down the whole chain.
+func (d *Dialer) Dial(t *Target) (*ssh.Client, error) {
+	jump := t.ProxyJump
+	if d.Jump != "" {
+		jump = d.Jump
+	}
+	return d.dial(t, jump, 0)
+}
+
+func (d *Dialer) dial(t *Target, jump string, depth int) (*ssh.Client, error) {
+	if depth > maxHops {
+		return nil, fmt.Errorf("%s: more than %d jump hosts (ProxyJump loop?)", t.Alias, maxHops)
+	}
+	var via *ssh.Client
+	for i, spec := range splitJump(jump) {
+		hop, err := d.resolveJump(spec)
+		if err != nil {
+			closeClient(via)
+			return nil, fmt.Errorf("jump host %s: %w", spec, err)
+		}
+		var c *ssh.Client
+		if i == 0 {
+			// The first hop may itself sit behind a ProxyJump.
+			c, err = d.dial(hop, hop.ProxyJump, depth+1)
+		} else {
+			c, err = d.dialVia(via, hop, true)
+		}
+		if err != nil {
+			closeClient(via)
+			return nil, fmt.Errorf("jump host %s: %w", hop, err)
+		}
+		via = c
+	}
+	if via != nil {
+		return d.dialVia(via, t, depth > 0)
+	}
+
+	agents := &connSet{}
+	cfg, err := d.config(t, depth > 0, agents)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t.Alias, err)
+	}
+	client, err := ssh.Dial("tcp", t.Addr(), cfg)
+	if err != nil {
+		agents.Close()
+		return nil, err
+	}
+	go func() {
+		client.Wait()
+		agents.Close()
+	}()
+	d.startKeepalive(client, t)
+	return client, nil
+}
+
+// dialVia opens a connection to t, a jump host if hop is set, tunnelled
+// through via. via is closed when the new client goes away, and on
+// failure.
+func (d *Dialer) dialVia(via *ssh.Client, t *Target, hop bool) (*ssh.Client, error) {
+	agents := &connSet{}
+	cfg, err := d.config(t, hop, agents)
+	if err != nil {
+		via.Close()
+		return nil, fmt.Errorf("%s: %w", t.Alias, err)
+	}
+	ctx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	conn, err := via.DialContext(ctx, "tcp", t.Addr())
+	if err != nil {
+		via.Close()
+		return nil, fmt.Errorf("dial %s via %s: %w", t.Addr(), via.RemoteAddr(), err)
+	}
+	// Tunnelled connections do not support deadlines, so bound the
+	// handshake by closing the connection instead.
+	if cfg.Timeout > 0 {
+		timer := time.AfterFunc(cfg.Timeout, func() { conn.Close() })
+		defer timer.Stop()
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.Addr(), cfg)
+	if err != nil {
+		conn.Close()
+		via.Close()
+		agents.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		client.Wait()
+		via.Close()
+		agents.Close()
+	}()
+	d.startKeepalive(client, t)
+	return client, nil
+}
+
+func (d *Dialer) startKeepalive(client *ssh.Client, t *Target) {
+	interval := t.ServerAliveInterval
+	if interval == 0 {
+		interval = d.KeepAlive
+	}
+	if interval == 0 {
+		interval = DefaultKeepAlive
+	}
+	countMax := t.ServerAliveCountMax
+	if countMax <= 0 {
+		countMax = 3
+	}
+	if interval > 0 {
+		go keepalive(client, interval, countMax)
+	}
+}
+
+// keepalive sends keepalive@openssh.com every interval and closes the
+// client after countMax consecutive unanswered requests.
+func keepalive(client *ssh.Client, interval time.Duration, countMax int) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	missed := 0
+	for range t.C {
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case err := <-reply:
+			if err != nil {
+				return // connection already closed
+			}
+			missed = 0
+			continue
+		case <-time.After(interval):
+			missed++
+		}
+		if missed >= countMax {
+			client.Close()
+			return
+		}
+	}
+}
+
+// splitJump splits a ProxyJump value into hop specs.
+func splitJump(jump string) []string {
+	var hops []string
+	for _, h := range strings.Split(jump, ",") {
+		if h = strings.TrimSpace(h); h != "" && !strings.EqualFold(h, "none") {
+			hops = append(hops, h)
+		}
+	}
+	return hops
+}
+
+func closeClient(c *ssh.Client) {
+	if c != nil {
+		c.Close()
+	}
+}
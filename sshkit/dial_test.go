@@ -0,0 +1,100 @@
+package sshkit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseKeepAlive(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{"30s", 30 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"15", 15 * time.Second, false},
+		{" 15 ", 15 * time.Second, false},
+		{"0", -1, false},
+		{"00", -1, false},
+		{"0s", -1, false},
+		{"off", -1, false},
+		{"OFF", -1, false},
+		{"no", -1, false},
+		{"none", -1, false},
+		{"-1", 0, true},
+		{"-5s", 0, true},
+		{"", 0, true},
+		{"1.5", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeepAlive(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseKeepAlive(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseReconnect(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		err  bool
+	}{
+		{"3", 3, false},
+		{" 3 ", 3, false},
+		{"1", 1, false},
+		{"0", -1, false},
+		{"-1", 0, true},
+		{"", 0, true},
+		{"off", 0, true},
+		{"3s", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReconnect(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseReconnect(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestAuthFor(t *testing.T) {
+	target := &Auth{KeyFiles: []string{"/keys/target"}}
+	jump := &Auth{KeyFiles: []string{"/keys/jump"}}
+	named := &Auth{KeyFiles: []string{"/keys/b1"}}
+	tests := []struct {
+		name  string
+		d     *Dialer
+		alias string
+		hop   bool
+		want  string
+	}{
+		{"target", &Dialer{Auth: target, JumpAuth: jump}, "web", false, "/keys/target"},
+		{"jump host", &Dialer{Auth: target, JumpAuth: jump}, "bastion", true, "/keys/jump"},
+		{"jump host without JumpAuth", &Dialer{Auth: target}, "bastion", true, "/keys/target"},
+		{"jump host in HopAuth", &Dialer{Auth: target, JumpAuth: jump, HopAuth: map[string]*Auth{"b1": named}}, "b1", true, "/keys/b1"},
+		{"target in HopAuth", &Dialer{Auth: target, HopAuth: map[string]*Auth{"web": named}}, "web", false, "/keys/b1"},
+	}
+	for _, tt := range tests {
+		a := tt.d.authFor(&Target{Alias: tt.alias}, tt.hop)
+		if len(a.KeyFiles) != 1 || a.KeyFiles[0] != tt.want {
+			t.Errorf("%s: keys %v, want %s", tt.name, a.KeyFiles, tt.want)
+		}
+	}
+}
+
+// TestDialerFromEnvJumpKey checks that SSH_JUMP_KEY reaches jump hosts
+// without SSH_JUMP, as for a ProxyJump in the ssh config.
+func TestDialerFromEnvJumpKey(t *testing.T) {
+	t.Setenv("SSH_JUMP", "")
+	t.Setenv("SSH_JUMP_KEY", "/keys/bastion")
+	d := DialerFromEnv()
+	if a := d.authFor(&Target{Alias: "from-proxyjump"}, true); len(a.KeyFiles) != 1 || a.KeyFiles[0] != "/keys/bastion" {
+		t.Errorf("jump host keys %v, want [/keys/bastion]", a.KeyFiles)
+	}
+	if a := d.authFor(&Target{Alias: "web"}, false); len(a.KeyFiles) == 1 && a.KeyFiles[0] == "/keys/bastion" {
+		t.Errorf("target offered the jump key")
+	}
+}
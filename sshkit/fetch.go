@@ -0,0 +1,56 @@
+package sshkit
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fetches maps a local file name to the function that downloads it once.
+var fetches sync.Map
+
+// FetchOnce downloads url to the local file unless the file already
+// exists. Each file is fetched at most once per process, so hosts
+// installing in parallel share one download instead of racing on the
+// file, and a failed download is not retried. The body is written to a
+// partial file that is renamed into place only when it is complete.
+func FetchOnce(url, file string) error {
+	fetch, _ := fetches.LoadOrStore(file, sync.OnceValue(func() error {
+		return fetchFile(url, file)
+	}))
+	return fetch.(func() error)()
+}
+
+func fetchFile(url, file string) error {
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		return err
+	}
+	log.Printf("Downloading %s...", url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	part := filepath.Join(filepath.Dir(file), partialName(filepath.Base(file)))
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, resp.Body); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(part)
+		return fmt.Errorf("get %s: %w", url, err)
+	}
+	return os.Rename(part, file)
+}
@@ -0,0 +1,62 @@
+package sshkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchOnce(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/missing.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("tarball"))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "pkg.tar.gz")
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := FetchOnce(srv.URL+"/pkg.tar.gz", file); err != nil {
+				t.Errorf("FetchOnce: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests for 8 concurrent fetches, want 1", n)
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "tarball" {
+		t.Errorf("fetched %q, %v; want %q", data, err, "tarball")
+	}
+
+	existing := filepath.Join(dir, "existing.tar.gz")
+	if err := os.WriteFile(existing, []byte("local"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := FetchOnce(srv.URL+"/existing.tar.gz", existing); err != nil || requests.Load() != 1 {
+		t.Errorf("existing file: %v, %d requests; want no download", err, requests.Load())
+	}
+
+	missing := filepath.Join(dir, "missing.tar.gz")
+	if err := FetchOnce(srv.URL+"/missing.tar.gz", missing); err == nil {
+		t.Error("404: no error")
+	}
+	for _, f := range []string{missing, filepath.Join(dir, partialName("missing.tar.gz"))} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("404 left %s behind", f)
+		}
+	}
+}
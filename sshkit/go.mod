@@ -0,0 +1,15 @@
+module sshkit
+
+go 1.24.2
+
+require (
+	github.com/pkg/sftp v1.13.9
+	golang.org/x/crypto v0.41.0
+	golang.org/x/term v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
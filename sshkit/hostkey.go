@@ -0,0 +1,238 @@
+// Package sshkit holds the SSH plumbing shared by the programs in this
+// repository, so that every entry point verifies hosts the same way.
+package sshkit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMode selects how unknown host keys are treated.
+type HostKeyMode string
+
+const (
+	// HostKeyTOFU trusts a host on first use and records its key.
+	HostKeyTOFU HostKeyMode = "tofu"
+	// HostKeyStrict refuses any host that is not already in known_hosts.
+	HostKeyStrict HostKeyMode = "strict"
+	// HostKeyOff disables verification (the old InsecureIgnoreHostKey behavior).
+	HostKeyOff HostKeyMode = "off"
+)
+
+// KnownHosts verifies server host keys against a set of known_hosts files.
+type KnownHosts struct {
+	Mode HostKeyMode
+	// Files are consulted in order. New keys accepted in TOFU mode are
+	// appended to the first one.
+	Files []string
+}
+
+// recordMu serializes appends to known_hosts files within this process.
+var recordMu sync.Mutex
+
+// KnownHostsFromEnv builds a KnownHosts from the environment:
+//
+//	SSH_HOST_KEY_CHECK  tofu (default), strict or off
+//	SSH_KNOWN_HOSTS     project-specific known_hosts file, checked before
+//	                    ~/.ssh/known_hosts and used to record new keys
+func KnownHostsFromEnv() *KnownHosts {
+	k := &KnownHosts{Mode: HostKeyTOFU}
+	if m := strings.ToLower(os.Getenv("SSH_HOST_KEY_CHECK")); m != "" {
+		k.Mode = HostKeyMode(m)
+	}
+	if p := os.Getenv("SSH_KNOWN_HOSTS"); p != "" {
+		k.Files = append(k.Files, expandHome(p))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		k.Files = append(k.Files, filepath.Join(home, ".ssh", "known_hosts"))
+	}
+	return k
+}
+
+// Apply installs the host key callback on cfg and restricts the negotiated
+// host key algorithms to the ones already on record for addr, so that a
+// host known by its ed25519 key is not reported as changed when it offers
+// an ecdsa key first.
+func (k *KnownHosts) Apply(cfg *ssh.ClientConfig, addr string) error {
+	switch k.Mode {
+	case HostKeyTOFU, HostKeyStrict, HostKeyOff:
+	default:
+		return fmt.Errorf("unknown host key mode %q (want tofu, strict or off)", k.Mode)
+	}
+	cfg.HostKeyCallback = k.Callback()
+	if k.Mode != HostKeyOff {
+		cfg.HostKeyAlgorithms = k.Algorithms(addr)
+	}
+	return nil
+}
+
+// Callback returns an ssh.HostKeyCallback implementing k.Mode. The files
+// are re-read on every call so keys recorded earlier in the same process
+// (for example by a previous hop) are honored.
+func (k *KnownHosts) Callback() ssh.HostKeyCallback {
+	if k.Mode == HostKeyOff {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		check, err := k.load()
+		if err != nil {
+			return err
+		}
+		err = check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return &HostKeyChangedError{Host: knownhosts.Normalize(hostname), Key: key, Known: keyErr.Want}
+		}
+		if k.Mode == HostKeyStrict {
+			return &UnknownHostError{Host: knownhosts.Normalize(hostname), Key: key}
+		}
+		return k.record(hostname, key)
+	}
+}
+
+// Algorithms lists the host key algorithms matching the keys on record for
+// addr, or nil when the host is unknown.
+func (k *KnownHosts) Algorithms(addr string) []string {
+	check, err := k.load()
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(addr, probeAddr(addr), probeKey{}), &keyErr) {
+		return nil
+	}
+	var algos []string
+	seen := map[string]bool{}
+	for _, known := range keyErr.Want {
+		for _, a := range algorithmsForKeyType(known.Key.Type()) {
+			if !seen[a] {
+				seen[a] = true
+				algos = append(algos, a)
+			}
+		}
+	}
+	return algos
+}
+
+// load builds a knownhosts callback from the files that exist.
+func (k *KnownHosts) load() (ssh.HostKeyCallback, error) {
+	var existing []string
+	for _, f := range k.Files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return func(string, net.Addr, ssh.PublicKey) error { return &knownhosts.KeyError{} }, nil
+	}
+	cb, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts: %w", err)
+	}
+	return cb, nil
+}
+
+// record appends key for hostname to the first known_hosts file.
+func (k *KnownHosts) record(hostname string, key ssh.PublicKey) error {
+	if len(k.Files) == 0 {
+		return &UnknownHostError{Host: knownhosts.Normalize(hostname), Key: key}
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	file := k.Files[0]
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	log.Printf("permanently added %s (%s %s) to %s",
+		knownhosts.Normalize(hostname), key.Type(), ssh.FingerprintSHA256(key), file)
+	return nil
+}
+
+// HostKeyChangedError is returned when a host presents a key that differs
+// from the one on record. This is either a reinstalled host or an attack.
+type HostKeyChangedError struct {
+	Host  string
+	Key   ssh.PublicKey
+	Known []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "REMOTE HOST IDENTIFICATION HAS CHANGED for %s: offered %s %s",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	for _, k := range e.Known {
+		fmt.Fprintf(&b, "; on record %s %s at %s:%d", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line)
+	}
+	b.WriteString("; remove the stale entry if the change is expected")
+	return b.String()
+}
+
+// UnknownHostError is returned in strict mode for hosts not in known_hosts.
+type UnknownHostError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *UnknownHostError) Error() string {
+	return fmt.Sprintf("host %s is not in known_hosts (%s %s); add it or use SSH_HOST_KEY_CHECK=tofu",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+}
+
+// algorithmsForKeyType maps a key type to the host key algorithms that can
+// present it.
+func algorithmsForKeyType(t string) []string {
+	if t == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{t}
+}
+
+// probeAddr turns "host:port" into a net.Addr for a lookup-only check.
+func probeAddr(addr string) net.Addr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "22"
+	}
+	p, _ := net.LookupPort("tcp", port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+// probeKey never matches a real key; checking it reveals what is on record.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "probe" }
+func (probeKey) Marshal() []byte                     { return []byte("sshkit-probe") }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// expandHome replaces a leading ~/ with the user's home directory.
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
@@ -0,0 +1,135 @@
+package sshkit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	key, other := newHostKey(t), newHostKey(t)
+	const host = "192.0.2.1:2222"
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+
+	tests := []struct {
+		name  string
+		mode  HostKeyMode
+		known string // line already in the second (user) file
+		key   ssh.PublicKey
+		want  string // "", "unknown", "changed"
+		saved bool   // key appended to the first (project) file
+	}{
+		{"tofu unknown", HostKeyTOFU, "", key, "", true},
+		{"tofu known", HostKeyTOFU, knownhosts.Line([]string{knownhosts.Normalize(host)}, key), key, "", false},
+		{"tofu changed", HostKeyTOFU, knownhosts.Line([]string{knownhosts.Normalize(host)}, other), key, "changed", false},
+		{"tofu other port", HostKeyTOFU, knownhosts.Line([]string{"192.0.2.1"}, other), key, "", true},
+		{"strict unknown", HostKeyStrict, "", key, "unknown", false},
+		{"strict known", HostKeyStrict, knownhosts.Line([]string{knownhosts.Normalize(host)}, key), key, "", false},
+		{"strict changed", HostKeyStrict, knownhosts.Line([]string{knownhosts.Normalize(host)}, other), key, "changed", false},
+		{"off changed", HostKeyOff, knownhosts.Line([]string{knownhosts.Normalize(host)}, other), key, "", false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		project, user := filepath.Join(dir, "project", "known_hosts"), filepath.Join(dir, "known_hosts")
+		if tt.known != "" {
+			if err := os.WriteFile(user, []byte(tt.known+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		k := &KnownHosts{Mode: tt.mode, Files: []string{project, user}}
+		err := k.Callback()(host, remote, tt.key)
+
+		var changed *HostKeyChangedError
+		var unknown *UnknownHostError
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want == "changed" && !errors.As(err, &changed):
+			t.Errorf("%s: got %v, want a HostKeyChangedError", tt.name, err)
+		case tt.want == "unknown" && !errors.As(err, &unknown):
+			t.Errorf("%s: got %v, want an UnknownHostError", tt.name, err)
+		}
+
+		data, _ := os.ReadFile(project)
+		saved := strings.Contains(string(data), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(tt.key))))
+		if saved != tt.saved {
+			t.Errorf("%s: key recorded = %v, want %v (%q)", tt.name, saved, tt.saved, data)
+		}
+		if tt.saved {
+			// Recorded, the key is now known, and a different one is not.
+			if err := k.Callback()(host, remote, tt.key); err != nil {
+				t.Errorf("%s: second use: %v", tt.name, err)
+			}
+			if err := k.Callback()(host, remote, other); !errors.As(err, &changed) {
+				t.Errorf("%s: other key after recording: got %v, want a HostKeyChangedError", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestKnownHostsNoFiles(t *testing.T) {
+	k := &KnownHosts{Mode: HostKeyTOFU}
+	var unknown *UnknownHostError
+	if err := k.Callback()("192.0.2.1:22", &net.TCPAddr{}, newHostKey(t)); !errors.As(err, &unknown) {
+		t.Errorf("got %v, want an UnknownHostError", err)
+	}
+}
+
+func TestKnownHostsApply(t *testing.T) {
+	key := newHostKey(t)
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("192.0.2.1:2222")}, key)
+	if err := os.WriteFile(file, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode  HostKeyMode
+		addr  string
+		algos []string
+		err   bool
+	}{
+		{HostKeyTOFU, "192.0.2.1:2222", []string{ssh.KeyAlgoED25519}, false},
+		{HostKeyStrict, "192.0.2.1:2222", []string{ssh.KeyAlgoED25519}, false},
+		{HostKeyTOFU, "192.0.2.2:22", nil, false},
+		{HostKeyOff, "192.0.2.1:2222", nil, false},
+		{"ask", "192.0.2.1:2222", nil, true},
+	}
+	for _, tt := range tests {
+		k := &KnownHosts{Mode: tt.mode, Files: []string{file}}
+		cfg := &ssh.ClientConfig{}
+		err := k.Apply(cfg, tt.addr)
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: Apply error %v", tt.mode, tt.addr, err)
+			continue
+		}
+		if err == nil && cfg.HostKeyCallback == nil {
+			t.Errorf("%s %s: no callback", tt.mode, tt.addr)
+		}
+		if !reflect.DeepEqual(cfg.HostKeyAlgorithms, tt.algos) {
+			t.Errorf("%s %s: algorithms %v, want %v", tt.mode, tt.addr, cfg.HostKeyAlgorithms, tt.algos)
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package sshkit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Host is an inventory entry. Vars are its effective settings: group vars
+// merged with its own. The connection settings are
+//
+//	host  address or ssh config alias (default Name)
+//	user  login user
+//	port  port
+//	key   private key file(s), comma-separated, tried before the defaults
+//	jump  jump hosts, as for ProxyJump; overrides SSH_JUMP for this host.
+//	      A hop naming an inventory host is dialed as that host's Target.
+//	jump_key         private key file(s) for the jump hosts (jump, SSH_JUMP or ProxyJump),
+//	                 comma-separated, offered instead of key; overrides SSH_JUMP_KEY
+//	keepalive        keepalive interval (15s, 15 or off); overrides SSH_KEEPALIVE
+//	keepalive_count  unanswered keepalives before the connection is dropped
+//	reconnect        redials of a lost connection (0 for none); overrides SSH_RECONNECT
+//
+// and any others are free for commands to use.
+type Host struct {
+	Name   string
+	Groups []string // every group the host is in, directly or through children, and "all"
+	Tags   []string
+	Vars   map[string]string
+	inv    *Inventory
+}
+
+// Inventory is a set of hosts, the groups they belong to and their tags.
+type Inventory struct {
+	Hosts  []*Host             // in file order
+	Groups map[string][]string // group name to member host names, children included
+	Tags   map[string][]string // tag to host names
+	byName map[string]*Host
+}
+
+// LoadInventory reads an inventory file, YAML when the name ends in .yaml
+// or .yml and INI otherwise.
+//
+// INI, in the style of Ansible:
+//
+//	# hosts before any section are ungrouped
+//	bastion host=203.0.113.10
+//	[web]
+//	web01 host=10.0.0.11 tags=canary,eu
+//	web02 host=10.0.0.12 port=2222
+//	[db]
+//	db01 host=10.0.0.21 role=primary
+//	[prod:children]
+//	web
+//	db
+//	[prod:vars]
+//	user=deploy
+//	jump=bastion
+//	tags=live
+//
+// A # or ; at the start of a line or after a space starts a comment, and
+// tags in a group's vars tag every host in the group.
+//
+// YAML:
+//
+//	hosts:
+//	  web01: {host: 10.0.0.11, tags: [canary, eu]}
+//	  web02: {host: 10.0.0.12, port: 2222}
+//	  db01:  {host: 10.0.0.21, vars: {role: primary}}
+//	groups:
+//	  web:  {hosts: [web01, web02]}
+//	  db:   {hosts: [db01]}
+//	  prod: {children: [web, db], tags: [live], vars: {user: deploy, jump: bastion}}
+//
+// Vars are applied from the "all" group, then from each group the host is
+// in, outer groups before the groups nested in them, then the host's own.
+func LoadInventory(file string) (*Inventory, error) {
+	data, err := os.ReadFile(expandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("inventory: %w", err)
+	}
+	b := newInventoryBuilder()
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = b.parseYAML(data)
+	default:
+		err = b.parseINI(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("inventory %s: %w", file, err)
+	}
+	inv, err := b.build()
+	if err != nil {
+		return nil, fmt.Errorf("inventory %s: %w", file, err)
+	}
+	return inv, nil
+}
+
+// inventoryBuilder collects the file's definitions before group
+// membership and vars are resolved.
+type inventoryBuilder struct {
+	hosts     []string
+	hostVars  map[string]map[string]string
+	hostTags  map[string][]string
+	groups    []string
+	members   map[string][]string
+	children  map[string][]string
+	groupVars map[string]map[string]string
+	groupTags map[string][]string
+	isGroup   map[string]bool
+}
+
+func newInventoryBuilder() *inventoryBuilder {
+	return &inventoryBuilder{
+		hostVars:  map[string]map[string]string{},
+		hostTags:  map[string][]string{},
+		members:   map[string][]string{},
+		children:  map[string][]string{},
+		groupVars: map[string]map[string]string{},
+		groupTags: map[string][]string{},
+		isGroup:   map[string]bool{},
+	}
+}
+
+func (b *inventoryBuilder) host(name string) map[string]string {
+	if v, ok := b.hostVars[name]; ok {
+		return v
+	}
+	b.hosts = append(b.hosts, name)
+	b.hostVars[name] = map[string]string{}
+	return b.hostVars[name]
+}
+
+func (b *inventoryBuilder) group(name string) {
+	if !b.isGroup[name] {
+		b.isGroup[name] = true
+		b.groups = append(b.groups, name)
+		b.groupVars[name] = map[string]string{}
+	}
+}
+
+func (b *inventoryBuilder) tag(host string, tags ...string) {
+	b.hostTags[host] = addTags(b.hostTags[host], tags)
+}
+
+// addTags appends the tags not yet in list.
+func addTags(list, tags []string) []string {
+	for _, t := range tags {
+		if t = strings.TrimSpace(t); t != "" && !slices.Contains(list, t) {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// stripComment cuts text at a # or ; that starts it or follows
+// whitespace, so values such as key=a#b are kept whole.
+func stripComment(text string) string {
+	for i, r := range text {
+		if (r == '#' || r == ';') && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t') {
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func (b *inventoryBuilder) parseINI(data []byte) error {
+	group, kind := "", ""
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for line := 1; sc.Scan(); line++ {
+		text := stripComment(sc.Text())
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "[") {
+			header := strings.TrimSpace(text)
+			if len(fields) != 1 || !strings.HasSuffix(header, "]") || len(header) < 3 {
+				return fmt.Errorf("line %d: bad section header %q", line, header)
+			}
+			group, kind, _ = strings.Cut(header[1:len(header)-1], ":")
+			if kind != "" && kind != "children" && kind != "vars" {
+				return fmt.Errorf("line %d: unknown section type %q", line, kind)
+			}
+			b.group(group)
+			continue
+		}
+		switch kind {
+		case "children":
+			if len(fields) != 1 {
+				return fmt.Errorf("line %d: expected a group name", line)
+			}
+			b.children[group] = append(b.children[group], fields[0])
+		case "vars":
+			k, v, ok := strings.Cut(strings.TrimSpace(text), "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("line %d: expected key=value", line)
+			}
+			k, v = strings.TrimSpace(k), strings.Trim(strings.TrimSpace(v), `"'`)
+			if k == "tags" {
+				b.groupTags[group] = addTags(b.groupTags[group], strings.Split(v, ","))
+				continue
+			}
+			b.groupVars[group][k] = v
+		default:
+			vars := b.host(fields[0])
+			for _, kv := range fields[1:] {
+				k, v, ok := strings.Cut(kv, "=")
+				if !ok || k == "" {
+					return fmt.Errorf("line %d: expected key=value, got %q", line, kv)
+				}
+				v = strings.Trim(v, `"'`)
+				if k == "tags" {
+					b.tag(fields[0], strings.Split(v, ",")...)
+					continue
+				}
+				vars[k] = v
+			}
+			if group != "" {
+				b.members[group] = append(b.members[group], fields[0])
+			}
+		}
+	}
+	return sc.Err()
+}
+
+type yamlHost struct {
+	Host string            `yaml:"host"`
+	User string            `yaml:"user"`
+	Port string            `yaml:"port"`
+	Key  string            `yaml:"key"`
+	Jump string            `yaml:"jump"`
+	Tags []string          `yaml:"tags"`
+	Vars map[string]string `yaml:"vars"`
+}
+
+type yamlGroup struct {
+	Hosts    []string          `yaml:"hosts"`
+	Children []string          `yaml:"children"`
+	Tags     []string          `yaml:"tags"`
+	Vars     map[string]string `yaml:"vars"`
+}
+
+func (b *inventoryBuilder) parseYAML(data []byte) error {
+	// Decoded through yaml.Node so that hosts keep their file order.
+	var doc struct {
+		Hosts  yaml.Node `yaml:"hosts"`
+		Groups yaml.Node `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	for _, kv := range pairs(&doc.Hosts) {
+		var h yamlHost
+		if err := kv[1].Decode(&h); err != nil {
+			return fmt.Errorf("host %s: %w", kv[0].Value, err)
+		}
+		name := kv[0].Value
+		vars := b.host(name)
+		for k, v := range h.Vars {
+			vars[k] = v
+		}
+		for k, v := range map[string]string{"host": h.Host, "user": h.User, "port": h.Port, "key": h.Key, "jump": h.Jump} {
+			if v != "" {
+				vars[k] = v
+			}
+		}
+		b.tag(name, h.Tags...)
+	}
+	for _, kv := range pairs(&doc.Groups) {
+		var g yamlGroup
+		if err := kv[1].Decode(&g); err != nil {
+			return fmt.Errorf("group %s: %w", kv[0].Value, err)
+		}
+		name := kv[0].Value
+		b.group(name)
+		for _, h := range g.Hosts {
+			b.host(h)
+			b.members[name] = append(b.members[name], h)
+		}
+		b.children[name] = append(b.children[name], g.Children...)
+		b.groupTags[name] = addTags(b.groupTags[name], g.Tags)
+		for k, v := range g.Vars {
+			b.groupVars[name][k] = v
+		}
+	}
+	return nil
+}
+
+// pairs returns the key/value nodes of a YAML mapping in order.
+func pairs(n *yaml.Node) [][2]*yaml.Node {
+	var out [][2]*yaml.Node
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		out = append(out, [2]*yaml.Node{n.Content[i], n.Content[i+1]})
+	}
+	return out
+}
+
+// build resolves nested groups and merges vars.
+func (b *inventoryBuilder) build() (*Inventory, error) {
+	for g, kids := range b.children {
+		for _, k := range kids {
+			if !b.isGroup[k] {
+				return nil, fmt.Errorf("group %s: unknown child group %q", g, k)
+			}
+		}
+	}
+	// depth orders vars: a group's vars apply before those of the groups
+	// nested in it.
+	depth := map[string]int{}
+	var visit func(g string, d int, path []string) error
+	visit = func(g string, d int, path []string) error {
+		if slices.Contains(path, g) {
+			return fmt.Errorf("group %s contains itself (%s)", g, strings.Join(append(path, g), " > "))
+		}
+		if d > depth[g] {
+			depth[g] = d
+		}
+		for _, k := range b.children[g] {
+			if err := visit(k, d+1, append(path, g)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, g := range b.groups {
+		if err := visit(g, 0, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	inv := &Inventory{Groups: map[string][]string{}, Tags: map[string][]string{}, byName: map[string]*Host{}}
+	for _, name := range b.hosts {
+		h := &Host{Name: name, Tags: b.hostTags[name], Vars: map[string]string{}, inv: inv}
+		inv.Hosts = append(inv.Hosts, h)
+		inv.byName[name] = h
+	}
+	// Walk each group's members and descendants.
+	for _, g := range b.groups {
+		seen := map[string]bool{}
+		var collect func(g string)
+		collect = func(grp string) {
+			for _, m := range b.members[grp] {
+				seen[m] = true
+			}
+			for _, k := range b.children[grp] {
+				collect(k)
+			}
+		}
+		collect(g)
+		for _, h := range inv.Hosts {
+			if seen[h.Name] {
+				h.Groups = append(h.Groups, g)
+				inv.Groups[g] = append(inv.Groups[g], h.Name)
+			}
+		}
+	}
+
+	order := append([]string(nil), b.groups...)
+	sort.SliceStable(order, func(i, j int) bool { return depth[order[i]] < depth[order[j]] })
+	for _, h := range inv.Hosts {
+		for k, v := range b.groupVars["all"] {
+			h.Vars[k] = v
+		}
+		for _, g := range order {
+			if g == "all" || !slices.Contains(h.Groups, g) {
+				continue
+			}
+			for k, v := range b.groupVars[g] {
+				h.Vars[k] = v
+			}
+		}
+		for k, v := range b.hostVars[h.Name] {
+			h.Vars[k] = v
+		}
+		if !slices.Contains(h.Groups, "all") {
+			h.Groups = append(h.Groups, "all")
+		}
+		// A host's own tags first, then its groups', outer groups first.
+		for _, g := range order {
+			if len(b.groupTags[g]) > 0 && slices.Contains(h.Groups, g) {
+				h.Tags = addTags(slices.Clip(h.Tags), b.groupTags[g])
+			}
+		}
+		for _, t := range h.Tags {
+			inv.Tags[t] = append(inv.Tags[t], h.Name)
+		}
+	}
+	inv.Groups["all"] = nil
+	for _, h := range inv.Hosts {
+		inv.Groups["all"] = append(inv.Groups["all"], h.Name)
+	}
+	return inv, nil
+}
+
+// Host returns the host called name.
+func (inv *Inventory) Host(name string) (*Host, bool) {
+	h, ok := inv.byName[name]
+	return h, ok
+}
+
+// ResolveJump resolves a [user@]host[:port] jump host spec: through the
+// host's Target if host is an inventory host name, else like Resolve. An
+// explicit user or port in spec wins, as it does for Resolve.
+func (inv *Inventory) ResolveJump(spec string) (*Target, error) {
+	name := spec
+	var user, port string
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		user, name = name[:i], name[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		name, port = h, p
+	}
+	h, ok := inv.byName[name]
+	if !ok {
+		return Resolve(spec)
+	}
+	t, err := h.Target()
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		t.User = user
+	}
+	if port != "" {
+		t.Port = port
+	}
+	return t, nil
+}
+
+// Select returns the hosts matching pattern, in inventory order. A pattern
+// is a list of terms separated by ':' or ','; each term is a group, tag or
+// host name, or a glob over them ("web*"), and "all" or "*" matches every
+// host. Plain terms add hosts, "&term" keeps only hosts also in term and
+// "!term" removes hosts, so "web:&prod:!web03" is the prod web servers
+// except web03.
+func (inv *Inventory) Select(pattern string) ([]*Host, error) {
+	var union, intersect, exclude [][]string
+	for _, term := range strings.FieldsFunc(pattern, func(r rune) bool { return r == ':' || r == ',' }) {
+		term = strings.TrimSpace(term)
+		list := &union
+		switch {
+		case strings.HasPrefix(term, "&"):
+			list, term = &intersect, term[1:]
+		case strings.HasPrefix(term, "!"):
+			list, term = &exclude, term[1:]
+		}
+		names, err := inv.match(term)
+		if err != nil {
+			return nil, err
+		}
+		*list = append(*list, names)
+	}
+	if len(union) == 0 {
+		union = [][]string{inv.Groups["all"]}
+	}
+
+	keep := map[string]bool{}
+	for _, names := range union {
+		for _, n := range names {
+			keep[n] = true
+		}
+	}
+	for _, names := range intersect {
+		in := map[string]bool{}
+		for _, n := range names {
+			in[n] = true
+		}
+		for n := range keep {
+			if !in[n] {
+				delete(keep, n)
+			}
+		}
+	}
+	for _, names := range exclude {
+		for _, n := range names {
+			delete(keep, n)
+		}
+	}
+
+	var hosts []*Host
+	for _, h := range inv.Hosts {
+		if keep[h.Name] {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("inventory: %q matches no hosts", pattern)
+	}
+	return hosts, nil
+}
+
+// match returns the host names a single pattern term stands for.
+func (inv *Inventory) match(term string) ([]string, error) {
+	if term == "" {
+		return nil, fmt.Errorf("inventory: empty pattern term")
+	}
+	if term == "all" || term == "*" {
+		return inv.Groups["all"], nil
+	}
+	glob := strings.ContainsAny(term, "*?[")
+	var names []string
+	matches := func(s string) bool {
+		if !glob {
+			return s == term
+		}
+		ok, _ := path.Match(term, s)
+		return ok
+	}
+	found := false
+	for g, members := range inv.Groups {
+		if matches(g) {
+			names, found = append(names, members...), true
+		}
+	}
+	for t, members := range inv.Tags {
+		if matches(t) {
+			names, found = append(names, members...), true
+		}
+	}
+	for _, h := range inv.Hosts {
+		if matches(h.Name) {
+			names, found = append(names, h.Name), true
+		}
+	}
+	if !found && !glob {
+		return nil, fmt.Errorf("inventory: no host, group or tag %q", term)
+	}
+	return names, nil
+}
+
+// HostTarget is a host to connect to and the Dialer that reaches it.
+type HostTarget struct {
+	Name   string
+	Target *Target
+	Dialer *Dialer
+}
+
+// SelectTargets returns the hosts of the inventory file matching pattern,
+// each with its Host.Target and Host.Dialer(d), or when pattern is empty
+// the single host spec, resolved like Resolve and dialed with d.
+func SelectTargets(d *Dialer, file, pattern, spec string) ([]HostTarget, error) {
+	if pattern == "" {
+		t, err := Resolve(spec)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", spec, err)
+		}
+		return []HostTarget{{Name: spec, Target: t, Dialer: d}}, nil
+	}
+	if file == "" {
+		return nil, fmt.Errorf("inventory: no file to select %q from", pattern)
+	}
+	inv, err := LoadInventory(file)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := inv.Select(pattern)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]HostTarget, 0, len(hosts))
+	for _, h := range hosts {
+		t, err := h.Target()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, HostTarget{Name: h.Name, Target: t, Dialer: h.Dialer(d)})
+	}
+	return targets, nil
+}
+
+// Target resolves the host through the ssh config like Resolve, with its
+// user, port, key, jump, keepalive and reconnect settings taking
+// precedence. The Target's Alias is the host's Name, also when the host
+// var gives another address.
+func (h *Host) Target() (*Target, error) {
+	spec := h.Name
+	if v := h.Vars["host"]; v != "" {
+		spec = v
+	}
+	t, err := Resolve(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.Name, err)
+	}
+	t.Alias = h.Name
+	if v := h.Vars["user"]; v != "" {
+		t.User = v
+	}
+	if v := h.Vars["port"]; v != "" {
+		t.Port = v
+	}
+	if v := h.Vars["key"]; v != "" {
+		t.IdentityFiles = append(keyList(v), t.IdentityFiles...)
+	}
+	if v, ok := h.Vars["jump"]; ok {
+		t.ProxyJump = v
+		if strings.EqualFold(v, "none") {
+			t.ProxyJump = ""
+		}
+	}
+	if v := h.Vars["keepalive"]; v != "" {
+		if t.ServerAliveInterval, err = ParseKeepAlive(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", h.Name, err)
+		}
+	}
+	if v := h.Vars["keepalive_count"]; v != "" {
+		if t.ServerAliveCountMax, err = strconv.Atoi(v); err != nil || t.ServerAliveCountMax < 1 {
+			return nil, fmt.Errorf("%s: invalid keepalive_count %q", h.Name, v)
+		}
+	}
+	if v := h.Vars["reconnect"]; v != "" {
+		if t.Reconnect, err = ParseReconnect(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", h.Name, err)
+		}
+	}
+	return t, nil
+}
+
+// Dialer returns d adjusted for the host: a jump setting in the inventory
+// replaces d.Jump (SSH_JUMP), jump hosts that are inventory hosts resolve
+// through Inventory.ResolveJump, and jump_key sets the keys its jump
+// hosts, from jump, SSH_JUMP or ProxyJump, are offered.
+func (h *Host) Dialer(d *Dialer) *Dialer {
+	if h.inv != nil {
+		hd := *d
+		hd.ResolveJump = h.inv.ResolveJump
+		d = &hd
+	}
+	if v, ok := h.Vars["jump"]; ok {
+		hd := *d
+		hd.Jump = v
+		if strings.EqualFold(v, "none") {
+			hd.Jump = ""
+		}
+		d = &hd
+	}
+	if v := h.Vars["jump_key"]; v != "" {
+		d = d.WithJumpKeys(keyList(v))
+	}
+	return d
+}
+
+// keyList splits a comma-separated list of key files.
+func keyList(v string) []string {
+	var keys []string
+	for _, k := range strings.Split(v, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, expandHome(k))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,356 @@
+package sshkit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testINI = `# hosts before any section are ungrouped
+bastion host=203.0.113.10
+[web]
+web01 host=10.0.0.11 tags=canary,eu
+web02 host=10.0.0.12 port=2222
+web03 host=10.0.0.13
+[stage]
+web03
+[db]
+db01 host=10.0.0.21 role=primary user=dba
+[prod:children]
+web
+db
+[prod:vars]
+user=deploy
+jump=bastion
+[web:vars]
+role=frontend
+[all:vars]
+role=none
+keepalive=15s
+`
+
+const testYAML = `hosts:
+  bastion: {host: 203.0.113.10}
+  web01: {host: 10.0.0.11, tags: [canary, eu]}
+  web02: {host: 10.0.0.12, port: 2222}
+  web03: {host: 10.0.0.13}
+  db01:  {host: 10.0.0.21, user: dba, vars: {role: primary}}
+groups:
+  web:   {hosts: [web01, web02, web03], vars: {role: frontend}}
+  stage: {hosts: [web03]}
+  db:    {hosts: [db01]}
+  prod:  {children: [web, db], vars: {user: deploy, jump: bastion}}
+  all:   {vars: {role: none, keepalive: 15s}}
+`
+
+func writeInventory(t *testing.T, name, data string) *Inventory {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := LoadInventory(file)
+	if err != nil {
+		t.Fatalf("LoadInventory(%s): %v", name, err)
+	}
+	return inv
+}
+
+func TestLoadInventory(t *testing.T) {
+	for _, name := range []string{"hosts.ini", "hosts.yaml"} {
+		data := testINI
+		if strings.HasSuffix(name, ".yaml") {
+			data = testYAML
+		}
+		inv := writeInventory(t, name, data)
+
+		var names []string
+		for _, h := range inv.Hosts {
+			names = append(names, h.Name)
+		}
+		if want := []string{"bastion", "web01", "web02", "web03", "db01"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: hosts %v, want %v", name, names, want)
+		}
+		if want := []string{"web01", "web02", "web03", "db01"}; !reflect.DeepEqual(inv.Groups["prod"], want) {
+			t.Errorf("%s: prod = %v, want %v", name, inv.Groups["prod"], want)
+		}
+		if want := []string{"web01"}; !reflect.DeepEqual(inv.Tags["eu"], want) {
+			t.Errorf("%s: tag eu = %v, want %v", name, inv.Tags["eu"], want)
+		}
+
+		tests := []struct {
+			host   string
+			vars   map[string]string
+			groups []string
+		}{
+			{"bastion", map[string]string{"host": "203.0.113.10", "role": "none", "keepalive": "15s"}, []string{"all"}},
+			{"web01", map[string]string{"host": "10.0.0.11", "role": "frontend", "user": "deploy", "jump": "bastion", "keepalive": "15s"}, []string{"web", "prod", "all"}},
+			{"web02", map[string]string{"host": "10.0.0.12", "port": "2222", "role": "frontend", "user": "deploy", "jump": "bastion", "keepalive": "15s"}, []string{"web", "prod", "all"}},
+			{"web03", map[string]string{"host": "10.0.0.13", "role": "frontend", "user": "deploy", "jump": "bastion", "keepalive": "15s"}, []string{"web", "stage", "prod", "all"}},
+			{"db01", map[string]string{"host": "10.0.0.21", "role": "primary", "user": "dba", "jump": "bastion", "keepalive": "15s"}, []string{"db", "prod", "all"}},
+		}
+		for _, tt := range tests {
+			h, ok := inv.Host(tt.host)
+			if !ok {
+				t.Errorf("%s: no host %s", name, tt.host)
+				continue
+			}
+			if !reflect.DeepEqual(h.Vars, tt.vars) {
+				t.Errorf("%s: %s vars %v, want %v", name, tt.host, h.Vars, tt.vars)
+			}
+			if !reflect.DeepEqual(h.Groups, tt.groups) {
+				t.Errorf("%s: %s groups %v, want %v", name, tt.host, h.Groups, tt.groups)
+			}
+		}
+	}
+}
+
+func TestInventorySelect(t *testing.T) {
+	inv := writeInventory(t, "hosts.ini", testINI)
+	tests := []struct {
+		pattern string
+		want    string // comma-separated host names, or "error"
+	}{
+		{"web:&prod:!web03", "web01,web02"},
+		{"web,&prod,!web03", "web01,web02"},
+		{"all", "bastion,web01,web02,web03,db01"},
+		{"*", "bastion,web01,web02,web03,db01"},
+		{"", "bastion,web01,web02,web03,db01"},
+		{"!web", "bastion,db01"},
+		{"prod:&stage", "web03"},
+		{"canary:db", "web01,db01"},
+		{"db01:web01", "web01,db01"},
+		{"web0*:!web02", "web01,web03"},
+		{"eu", "web01"},
+		{"db*", "db01"},
+		{"nosuch", "error"},
+		{"web:&db", "error"},
+		{"web:&", "error"},
+		{"zz*", "error"},
+	}
+	for _, tt := range tests {
+		hosts, err := inv.Select(tt.pattern)
+		got := "error"
+		if err == nil {
+			var names []string
+			for _, h := range hosts {
+				names = append(names, h.Name)
+			}
+			got = strings.Join(names, ",")
+		}
+		if got != tt.want {
+			t.Errorf("Select(%q) = %s (%v), want %s", tt.pattern, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadInventoryErrors(t *testing.T) {
+	tests := []struct {
+		name, data string
+	}{
+		{"bad header", "[web\nweb01\n"},
+		{"unknown section type", "[web:hosts]\nweb01\n"},
+		{"bad host var", "[web]\nweb01 port\n"},
+		{"bad group var", "[web:vars]\nuser\n"},
+		{"unknown child", "[prod:children]\nweb\n"},
+		{"group loop", "[a:children]\nb\n[b:children]\na\n"},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "hosts.ini")
+		if err := os.WriteFile(file, []byte(tt.data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadInventory(file); err == nil {
+			t.Errorf("%s: LoadInventory succeeded", tt.name)
+		}
+	}
+}
+
+func TestHostDialer(t *testing.T) {
+	t.Setenv("SSH_CONFIG", filepath.Join(t.TempDir(), "none"))
+	d := &Dialer{Auth: &Auth{Order: []string{AuthPassword}, KeyFiles: []string{"/keys/target"}}, Jump: "u@env-bastion"}
+	tests := []struct {
+		vars     map[string]string
+		jump     string
+		jumpKeys []string // JumpAuth's key files, nil for no JumpAuth
+	}{
+		{nil, "u@env-bastion", nil},
+		{map[string]string{"jump": "none"}, "", nil},
+		{map[string]string{"jump": "b1,u@b2:2222"}, "b1,u@b2:2222", nil},
+		{map[string]string{"jump_key": "/keys/jump"}, "u@env-bastion", []string{"/keys/jump"}},
+		{map[string]string{"jump": "b1,u@b2:2222", "jump_key": "/keys/a, /keys/b"}, "b1,u@b2:2222", []string{"/keys/a", "/keys/b"}},
+		{map[string]string{"jump": "none", "jump_key": "/keys/jump"}, "", []string{"/keys/jump"}},
+	}
+	for _, tt := range tests {
+		hd := (&Host{Name: "h", Vars: tt.vars}).Dialer(d)
+		if hd.Jump != tt.jump {
+			t.Errorf("%v: Jump %q, want %q", tt.vars, hd.Jump, tt.jump)
+		}
+		var got []string
+		if a := hd.JumpAuth; a != nil {
+			got = a.KeyFiles
+			if a.Order[0] != AuthKey {
+				t.Errorf("%v: jump host order %v does not start with key", tt.vars, a.Order)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.jumpKeys) {
+			t.Errorf("%v: jump host keys %v, want %v", tt.vars, got, tt.jumpKeys)
+		}
+	}
+	if d.JumpAuth != nil || d.Jump != "u@env-bastion" {
+		t.Errorf("Host.Dialer changed the shared Dialer: %+v", d)
+	}
+}
+
+// TestHostDialerInventoryJump checks that a jump host named in the
+// inventory is dialed with its inventory address, user, port and key.
+func TestHostDialerInventoryJump(t *testing.T) {
+	t.Setenv("SSH_CONFIG", filepath.Join(t.TempDir(), "none"))
+	inv := writeInventory(t, "hosts.ini", `bastion host=203.0.113.10 user=ops port=2022 key=/keys/bastion
+inner host=10.0.0.2 user=ops jump=bastion
+[web]
+web01 host=10.0.0.11 user=deploy jump=bastion
+`)
+	web01, _ := inv.Host("web01")
+	d := &Dialer{Auth: &Auth{}}
+	hd := web01.Dialer(d)
+	if d.ResolveJump != nil {
+		t.Errorf("Host.Dialer changed the shared Dialer")
+	}
+	tests := []struct {
+		spec string
+		want string // Alias, String() and ProxyJump
+		keys []string
+	}{
+		{"bastion", "bastion ops@203.0.113.10:2022 ", []string{"/keys/bastion"}},
+		{"admin@bastion", "bastion admin@203.0.113.10:2022 ", []string{"/keys/bastion"}},
+		{"bastion:22", "bastion ops@203.0.113.10:22 ", []string{"/keys/bastion"}},
+		{"inner", "inner ops@10.0.0.2:22 bastion", nil},
+		{"u@elsewhere:2200", "elsewhere u@elsewhere:2200 ", nil},
+	}
+	for _, tt := range tests {
+		target, err := hd.resolveJump(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if got := target.Alias + " " + target.String() + " " + target.ProxyJump; got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.spec, got, tt.want)
+		}
+		if !reflect.DeepEqual(target.IdentityFiles, tt.keys) {
+			t.Errorf("%s: keys %v, want %v", tt.spec, target.IdentityFiles, tt.keys)
+		}
+	}
+	if target, err := (&Host{Name: "h"}).Dialer(d).resolveJump("bastion"); err != nil || target.HostName != "bastion" {
+		t.Errorf("host outside an inventory: %v, %v; want the ssh config's bastion", target, err)
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	t.Setenv("SSH_CONFIG", filepath.Join(t.TempDir(), "none"))
+	inv := filepath.Join(t.TempDir(), "hosts.ini")
+	if err := os.WriteFile(inv, []byte(testINI), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := &Dialer{Auth: &Auth{}}
+	tests := []struct {
+		file, pattern, spec string
+		want                []string // Name and Target.String()
+		err                 bool
+	}{
+		{"", "", "u@single:2200", []string{"u@single:2200 u@single:2200"}, false},
+		{inv, "", "u@single", []string{"u@single u@single:22"}, false},
+		{inv, "web:!web02", "u@ignored", []string{"web01 deploy@10.0.0.11:22", "web03 deploy@10.0.0.13:22"}, false},
+		{inv, "db", "", []string{"db01 dba@10.0.0.21:22"}, false},
+		{"", "web", "", nil, true},
+		{inv, "nothing", "", nil, true},
+		{"", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		targets, err := SelectTargets(d, tt.file, tt.pattern, tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("%q %q: error %v", tt.pattern, tt.spec, err)
+			continue
+		}
+		var got []string
+		for _, ht := range targets {
+			got = append(got, ht.Name+" "+ht.Target.String())
+			if ht.Dialer == nil {
+				t.Errorf("%q %q: %s has no Dialer", tt.pattern, tt.spec, ht.Name)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q %q: got %q, want %q", tt.pattern, tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestLoadInventoryINISyntax(t *testing.T) {
+	inv := writeInventory(t, "hosts.ini", `; a comment
+  # an indented comment
+web01 host=10.0.0.11 url=http://x/#top motto=a;b ; trailing comment
+web02 host=10.0.0.12	# after a tab
+[web]
+web01
+web02 tags=eu
+[web:vars]
+tags=frontend, canary
+role=web#1 # comment
+[prod:children]
+web
+[prod:vars]
+tags=live
+`)
+	tests := []struct {
+		host string
+		vars map[string]string
+		tags []string
+	}{
+		{"web01", map[string]string{"host": "10.0.0.11", "url": "http://x/#top", "motto": "a;b", "role": "web#1"}, []string{"live", "frontend", "canary"}},
+		{"web02", map[string]string{"host": "10.0.0.12", "role": "web#1"}, []string{"eu", "live", "frontend", "canary"}},
+	}
+	for _, tt := range tests {
+		h, ok := inv.Host(tt.host)
+		if !ok {
+			t.Fatalf("no host %s", tt.host)
+		}
+		if !reflect.DeepEqual(h.Vars, tt.vars) {
+			t.Errorf("%s: vars %v, want %v", tt.host, h.Vars, tt.vars)
+		}
+		if !reflect.DeepEqual(h.Tags, tt.tags) {
+			t.Errorf("%s: tags %v, want %v", tt.host, h.Tags, tt.tags)
+		}
+	}
+	if hosts, err := inv.Select("canary:&live"); err != nil || len(hosts) != 2 {
+		t.Errorf("Select(canary:&live) = %v, %v; want both hosts", hosts, err)
+	}
+}
+
+func TestHostTarget(t *testing.T) {
+	t.Setenv("SSH_CONFIG", filepath.Join(t.TempDir(), "none"))
+	tests := []struct {
+		vars map[string]string
+		want string // Alias and String()
+		err  bool
+	}{
+		{map[string]string{"user": "u"}, "web01 u@web01:22", false},
+		{map[string]string{"host": "10.0.0.11", "user": "u", "port": "2222"}, "web01 u@10.0.0.11:2222", false},
+		{map[string]string{"host": "deploy@10.0.0.11:2200"}, "web01 deploy@10.0.0.11:2200", false},
+		{map[string]string{"user": "u", "keepalive": "soon"}, "", true},
+		{map[string]string{"user": "u", "reconnect": "-1"}, "", true},
+	}
+	for _, tt := range tests {
+		target, err := (&Host{Name: "web01", Vars: tt.vars}).Target()
+		if (err != nil) != tt.err {
+			t.Errorf("%v: error %v", tt.vars, err)
+			continue
+		}
+		if err == nil {
+			if got := target.Alias + " " + target.String(); got != tt.want {
+				t.Errorf("%v: got %q, want %q", tt.vars, got, tt.want)
+			}
+		}
+	}
+}
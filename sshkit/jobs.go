@@ -0,0 +1,246 @@
+package sshkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultJobDir is where StartJob keeps its job directories, relative to
+// the remote login directory.
+const DefaultJobDir = ".sshkit/jobs"
+
+// JobState is what became of a detached job.
+type JobState string
+
+const (
+	JobRunning JobState = "running"
+	JobExited  JobState = "exited"
+	JobKilled  JobState = "killed" // stopped by KillJob
+	JobLost    JobState = "lost"   // gone without an exit status, e.g. by a reboot
+)
+
+// Job is a command started by StartJob. Its directory on the remote host
+// holds the command as Cmd shows it, the pid, the start time, the
+// combined output (log) and, once it has finished, its exit status.
+type Job struct {
+	ID      string    `json:"id"`
+	State   JobState  `json:"state"`
+	Exit    int       `json:"exit"` // -1 unless State is JobExited
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Cmd     string    `json:"cmd"`
+}
+
+// ErrNoJob is returned for a job ID that has no job directory.
+var ErrNoJob = errors.New("no such job")
+
+// jobStartScript starts $2 detached from the session: in its own session
+// (setsid, or nohup where there is none) with stdin from /dev/null and
+// stdout and stderr appended to the log, so it survives the connection
+// closing. $3 is recorded as its command line. The wrapper records the
+// exit status when the command finishes.
+const jobStartScript = `umask 077
+mkdir -p "$1" || exit 1
+d=$(cd "$1" && pwd) || exit 1
+printf '%s\n' "$3" > "$d/cmd"
+date +%s > "$d/started"
+run=nohup
+command -v setsid >/dev/null 2>&1 && run=setsid
+$run sh -c 'sh -c "$1" >> "$2/log" 2>&1; echo $? > "$2/exit.tmp" && mv "$2/exit.tmp" "$2/exit"' sh "$2" "$d" < /dev/null > /dev/null 2>&1 &
+echo $! > "$d/pid"
+`
+
+// jobStatusScript prints a tab-separated line per job in directory $1:
+// the jobs named by the other arguments, or all of them.
+const jobStatusScript = `cd "$1" 2>/dev/null || exit 0
+shift
+[ $# -gt 0 ] || set -- *
+for id; do
+	[ -f "$id/pid" ] || continue
+	pid=$(cat "$id/pid") code=
+	if [ -f "$id/killed" ]; then state=killed
+	elif [ -f "$id/exit" ]; then state=exited code=$(cat "$id/exit")
+	elif kill -0 "$pid" 2>/dev/null; then state=running
+	else state=lost
+	fi
+	printf '%s\t%s\t%s\t%s\t%s\t%s\n' "$id" "$state" "$code" "$pid" "$(cat "$id/started")" "$(head -n 1 "$id/cmd")"
+done
+`
+
+// jobLogsScript prints the log of job $1; with $2 set to follow it keeps
+// printing until the job has ended and then exits with its status.
+const jobLogsScript = `cd "$1" || exit 1
+[ -n "$2" ] || exec cat log
+tail -n +1 -f log &
+t=$!
+while [ ! -f exit ] && [ ! -f killed ] && kill -0 "$(cat pid)" 2>/dev/null; do sleep 1; done
+sleep 1
+kill "$t"
+[ -f exit ] && exit "$(cat exit)"
+exit 1
+`
+
+// jobKillScript stops job $1 and everything it started: SIGTERM to its
+// process group, SIGKILL to what is left after $2 seconds.
+const jobKillScript = `cd "$1" || exit 1
+[ -f exit ] && exit 0
+pid=$(cat pid)
+touch killed
+sig() { kill -"$1" -"$pid" 2>/dev/null || { pkill -"$1" -P "$pid" 2>/dev/null; kill -"$1" "$pid" 2>/dev/null; }; }
+sig TERM
+i=0
+while kill -0 "$pid" 2>/dev/null && [ "$i" -lt "$2" ]; do sleep 1; i=$((i+1)); done
+kill -0 "$pid" 2>/dev/null && sig KILL
+exit 0
+`
+
+// jobDir is the remote directory of job id.
+func (c *Client) jobDir(id string) string {
+	dir := c.JobDir
+	if dir == "" {
+		dir = DefaultJobDir
+	}
+	if id == "" {
+		return dir
+	}
+	return dir + "/" + id
+}
+
+// StartJob starts cmd detached on the remote host and returns once it is
+// running. The job outlives the connection; later connections find it by
+// its ID with Jobs, JobStatus, JobLogs and KillJob. cmd's Env is put on
+// the command line, as no session is left to carry it. display is the
+// command the job's Cmd shows, such as what the user typed before it was
+// wrapped in a Script; empty for cmd's own command line.
+func (c *Client) StartJob(cmd *Command, display string) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Output(startJobScript(c.jobDir(id), cmd, display).String()); err != nil {
+		return nil, fmt.Errorf("start job: %w", err)
+	}
+	return c.JobStatus(id)
+}
+
+// startJobScript is the command that starts cmd as the job in dir.
+func startJobScript(dir string, cmd *Command, display string) *Command {
+	line := cmd.String()
+	if display == "" {
+		display = line
+	}
+	return Script(jobStartScript, dir, line, display)
+}
+
+// newJobID returns a job ID that sorts by start time.
+func newJobID() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// checkJobID rejects IDs that are not a single path element.
+func checkJobID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, "/\x00") {
+		return fmt.Errorf("invalid job id %q", id)
+	}
+	return nil
+}
+
+// Jobs returns every job in the job directory, oldest first.
+func (c *Client) Jobs() ([]Job, error) {
+	return c.jobs()
+}
+
+// JobStatus returns job id; the error wraps ErrNoJob if there is none.
+func (c *Client) JobStatus(id string) (*Job, error) {
+	if err := checkJobID(id); err != nil {
+		return nil, err
+	}
+	jobs, err := c.jobs(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("job %s: %w", id, ErrNoJob)
+	}
+	return &jobs[0], nil
+}
+
+func (c *Client) jobs(ids ...string) ([]Job, error) {
+	out, err := c.Output(Script(jobStatusScript, append([]string{c.jobDir("")}, ids...)...).String())
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	return parseJobs(out), nil
+}
+
+// parseJobs reads the lines printed by jobStatusScript, skipping any that
+// are cut short.
+func parseJobs(out string) []Job {
+	var jobs []Job
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.SplitN(line, "\t", 6)
+		if len(f) < 6 {
+			continue
+		}
+		j := Job{ID: f[0], State: JobState(f[1]), Exit: -1, Cmd: f[5]}
+		if j.State == JobExited {
+			j.Exit, _ = strconv.Atoi(f[2])
+		}
+		j.PID, _ = strconv.Atoi(f[3])
+		if sec, err := strconv.ParseInt(f[4], 10, 64); err == nil {
+			j.Started = time.Unix(sec, 0)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// JobLogs copies the output of job id to w. With follow it keeps copying
+// until the job ends, and returns a *CommandError if it did not exit 0.
+func (c *Client) JobLogs(ctx context.Context, id string, follow bool, w io.Writer) error {
+	if _, err := c.JobStatus(id); err != nil {
+		return err
+	}
+	flag := ""
+	if follow {
+		flag = "follow"
+	}
+	cmd := Script(jobLogsScript, c.jobDir(id), flag).String()
+	res, err := c.StreamContext(ctx, cmd, w, w, DefaultTail)
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return &CommandError{Cmd: cmd, ExitCode: res.ExitCode}
+	}
+	return nil
+}
+
+// KillJob stops job id and the processes it started: SIGTERM, then
+// SIGKILL for what is still running after KillGrace. A job that has
+// already exited is left as it is.
+func (c *Client) KillJob(id string) (*Job, error) {
+	if _, err := c.JobStatus(id); err != nil {
+		return nil, err
+	}
+	grace := c.KillGrace
+	if grace <= 0 {
+		grace = DefaultKillGrace
+	}
+	secs := strconv.Itoa(int((grace + time.Second - 1) / time.Second))
+	if _, err := c.Output(Script(jobKillScript, c.jobDir(id), secs).String()); err != nil {
+		return nil, fmt.Errorf("kill job %s: %w", id, err)
+	}
+	return c.JobStatus(id)
+}
@@ -0,0 +1,98 @@
+package sshkit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseJobs(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []Job
+	}{
+		{"none", "", nil},
+		{"running", "20240101-120000-a1b2c3\trunning\t\t4242\t1704110400\tsleep 60\n",
+			[]Job{{ID: "20240101-120000-a1b2c3", State: JobRunning, Exit: -1, PID: 4242, Started: time.Unix(1704110400, 0), Cmd: "sleep 60"}}},
+		{"exited", "j1\texited\t3\t10\t1704110400\tfalse\n",
+			[]Job{{ID: "j1", State: JobExited, Exit: 3, PID: 10, Started: time.Unix(1704110400, 0), Cmd: "false"}}},
+		{"killed has no exit code", "j1\tkilled\t\t10\t1704110400\tsleep 60\n",
+			[]Job{{ID: "j1", State: JobKilled, Exit: -1, PID: 10, Started: time.Unix(1704110400, 0), Cmd: "sleep 60"}}},
+		{"lost", "j1\tlost\t\t10\t1704110400\tmake\n",
+			[]Job{{ID: "j1", State: JobLost, Exit: -1, PID: 10, Started: time.Unix(1704110400, 0), Cmd: "make"}}},
+		{"tab in the command", "j1\trunning\t\t10\t1704110400\tprintf 'a\\tb'\tx\n",
+			[]Job{{ID: "j1", State: JobRunning, Exit: -1, PID: 10, Started: time.Unix(1704110400, 0), Cmd: "printf 'a\\tb'\tx"}}},
+		{"empty command", "j1\trunning\t\t10\t1704110400\t\n",
+			[]Job{{ID: "j1", State: JobRunning, Exit: -1, PID: 10, Started: time.Unix(1704110400, 0)}}},
+		{"no start time", "j1\trunning\t\t10\t\tsleep 1\n",
+			[]Job{{ID: "j1", State: JobRunning, Exit: -1, PID: 10, Cmd: "sleep 1"}}},
+		{"several, short line skipped",
+			"j1\texited\t0\t10\t1704110400\ttrue\n" +
+				"j2\trunning\t10\n" +
+				"j3\trunning\t\t11\t1704110460\tsleep 9\n",
+			[]Job{
+				{ID: "j1", State: JobExited, Exit: 0, PID: 10, Started: time.Unix(1704110400, 0), Cmd: "true"},
+				{ID: "j3", State: JobRunning, Exit: -1, PID: 11, Started: time.Unix(1704110460, 0), Cmd: "sleep 9"},
+			}},
+	}
+	for _, tt := range tests {
+		if got := parseJobs(tt.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n  %+v\nwant\n  %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckJobID(t *testing.T) {
+	for _, id := range []string{"20240101-120000-a1b2c3", "j1", "..."} {
+		if err := checkJobID(id); err != nil {
+			t.Errorf("checkJobID(%q): %v", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "../etc", "a/b", "a\x00b"} {
+		if err := checkJobID(id); err == nil {
+			t.Errorf("checkJobID(%q) accepted", id)
+		}
+	}
+}
+
+// TestStartJobScript runs the job scripts locally and checks that a job
+// lists the command it was given, not the Script wrapping it.
+func TestStartJobScript(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		id      string
+		cmd     *Command
+		display string
+		want    string
+	}{
+		{"j1", Script("echo hi from $0"), "echo hi from $0", "echo hi from $0"},
+		{"j2", NewCommand("echo", "it's"), "", `echo 'it'\''s'`},
+	}
+	for _, tt := range tests {
+		jobDir := filepath.Join(dir, tt.id)
+		if out, err := exec.Command("sh", "-c", startJobScript(jobDir, tt.cmd, tt.display).String()).CombinedOutput(); err != nil {
+			t.Fatalf("%s: start: %v\n%s", tt.id, err, out)
+		}
+		for i := 0; i < 50; i++ {
+			if _, err := os.Stat(filepath.Join(jobDir, "exit")); err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		out, err := exec.Command("sh", "-c", jobStatusScript, "sh", dir, tt.id).Output()
+		if err != nil {
+			t.Fatalf("%s: status: %v", tt.id, err)
+		}
+		jobs := parseJobs(string(out))
+		if len(jobs) != 1 || jobs[0].Cmd != tt.want || jobs[0].State != JobExited || jobs[0].Exit != 0 {
+			t.Errorf("%s: got %+v, want Cmd %q, exited 0", tt.id, jobs, tt.want)
+		}
+	}
+	if log, _ := os.ReadFile(filepath.Join(dir, "j1", "log")); string(log) != "hi from sh\n" {
+		t.Errorf("j1 log %q, want %q", log, "hi from sh\n")
+	}
+}
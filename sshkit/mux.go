@@ -0,0 +1,301 @@
+package sshkit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A mux broker keeps one authenticated connection open and lets later
+// processes open channels on it through a Unix socket, like OpenSSH's
+// ControlMaster. The socket speaks the SSH protocol itself: the broker
+// accepts local SSH connections without authentication (the socket lives
+// in a 0700 directory) and relays every channel and request to the
+// upstream client, so callers get an ordinary *ssh.Client back.
+
+// muxCloseRequest is the global request that asks a broker to exit.
+const muxCloseRequest = "close@sshkit"
+
+// ErrMuxRunning is returned by ServeMux when another broker already
+// answers on the socket.
+var ErrMuxRunning = errors.New("mux broker already running")
+
+// MuxPath returns the control socket path for t as reached by d, creating
+// the private directory it lives in ($XDG_RUNTIME_DIR or the temp dir).
+func (d *Dialer) MuxPath(t *Target) (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = os.TempDir()
+	}
+	dir := filepath.Join(base, fmt.Sprintf("sshkit-%d", os.Getuid()))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("mux dir: %w", err)
+	}
+	// Another user may have made the directory first, under our name.
+	if err := checkOwned(dir, true); err != nil {
+		return "", fmt.Errorf("mux dir: %w", err)
+	}
+	jump := t.ProxyJump
+	if d.Jump != "" {
+		jump = d.Jump
+	}
+	// "none" and "" both mean no jump hosts.
+	jump = strings.Join(splitJump(jump), ",")
+	sum := sha256.Sum256([]byte(t.String() + "|" + jump))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".sock"), nil
+}
+
+// checkOwned returns an error unless path, not followed if it is a
+// symlink, is a directory (dir) or socket owned by the current user, and
+// a directory is private to them.
+func checkOwned(path string, dir bool) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dir && !fi.IsDir():
+		return fmt.Errorf("%s is not a directory", path)
+	case !dir && fi.Mode().Type() != fs.ModeSocket:
+		return fmt.Errorf("%s is not a socket", path)
+	}
+	if uid, ok := fileOwner(fi); ok && uid != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not the current user", path, uid)
+	}
+	if dir && fi.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%s must be private to the current user", path)
+	}
+	return nil
+}
+
+// DialMux connects to the broker listening on path, which must be a
+// socket of the current user's in a directory only they can use.
+func DialMux(path string) (*ssh.Client, error) {
+	if err := checkOwned(filepath.Dir(path), true); err != nil {
+		return nil, fmt.Errorf("mux dir: %w", err)
+	}
+	if err := checkOwned(path, false); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, "mux", &ssh.ClientConfig{
+		User: "mux",
+		// The broker's key is ephemeral; checkOwned on the socket and
+		// its directory is what authenticates it.
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mux handshake: %w", err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// CloseMux asks the broker on path to exit.
+func CloseMux(path string) error {
+	c, err := DialMux(path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, _, err := c.SendRequest(muxCloseRequest, true, nil); err != nil {
+		return fmt.Errorf("mux close: %w", err)
+	}
+	return nil
+}
+
+// ServeMux relays local connections on path to upstream until it is asked
+// to close, upstream goes away, or no client has been connected for idle
+// (zero means no idle timeout). The socket is removed on return.
+func ServeMux(upstream *ssh.Client, path string, idle time.Duration) error {
+	if c, err := DialMux(path); err == nil {
+		c.Close()
+		return ErrMuxRunning
+	}
+	_ = os.Remove(path) // stale socket from a broker that died
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("mux listen: %w", err)
+	}
+	defer os.Remove(path)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return err
+	}
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	m := &muxServer{upstream: upstream, ln: ln, idle: idle}
+	m.setActive(0)
+	go func() {
+		upstream.Wait()
+		m.shutdown()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if m.isClosed() {
+				return nil
+			}
+			return fmt.Errorf("mux accept: %w", err)
+		}
+		m.setActive(+1)
+		go func() {
+			defer m.setActive(-1)
+			m.serveConn(conn, cfg)
+		}()
+	}
+}
+
+type muxServer struct {
+	upstream *ssh.Client
+	ln       net.Listener
+	idle     time.Duration
+
+	mu     sync.Mutex
+	active int
+	timer  *time.Timer
+	closed bool
+}
+
+// setActive adjusts the live connection count and arms the idle timer
+// when it drops to zero.
+func (m *muxServer) setActive(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active += delta
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	if m.active == 0 && m.idle > 0 && !m.closed {
+		m.timer = time.AfterFunc(m.idle, m.shutdown)
+	}
+}
+
+func (m *muxServer) shutdown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		m.ln.Close()
+		m.upstream.Close()
+	}
+}
+
+func (m *muxServer) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+func (m *muxServer) serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+
+	go func() {
+		for r := range reqs {
+			switch r.Type {
+			case muxCloseRequest:
+				r.Reply(true, nil)
+				m.shutdown()
+			case "keepalive@openssh.com":
+				r.Reply(true, nil)
+			default:
+				// Port forwarding would need the upstream's reverse
+				// channels routed back here, which the broker does not do.
+				r.Reply(false, nil)
+			}
+		}
+	}()
+	for nc := range chans {
+		go m.relayChannel(nc)
+	}
+}
+
+// relayChannel opens the same channel upstream and pipes data, stderr and
+// requests between the two until both sides are closed.
+func (m *muxServer) relayChannel(nc ssh.NewChannel) {
+	up, upReqs, err := m.upstream.OpenChannel(nc.ChannelType(), nc.ExtraData())
+	if err != nil {
+		var oce *ssh.OpenChannelError
+		if errors.As(err, &oce) {
+			nc.Reject(oce.Reason, oce.Message)
+		} else {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	down, downReqs, err := nc.Accept()
+	if err != nil {
+		up.Close()
+		return
+	}
+
+	// upReqs closes when the remote closes its side; exit-status arrives
+	// on it, so it must drain before the local channel is closed.
+	upDone := make(chan struct{})
+	go func() {
+		relayRequests(down, upReqs)
+		close(upDone)
+	}()
+	go func() {
+		relayRequests(up, downReqs)
+		up.Close() // the local side went away first
+	}()
+	go func() {
+		io.Copy(up, down)
+		up.CloseWrite()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		io.Copy(down, up)
+		wg.Done()
+	}()
+	go func() {
+		io.Copy(down.Stderr(), up.Stderr())
+		wg.Done()
+	}()
+	wg.Wait()
+	down.CloseWrite()
+	<-upDone
+	down.Close()
+	up.Close()
+}
+
+func relayRequests(dst ssh.Channel, reqs <-chan *ssh.Request) {
+	for r := range reqs {
+		ok, err := dst.SendRequest(r.Type, r.WantReply, r.Payload)
+		if r.WantReply {
+			r.Reply(ok && err == nil, nil)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package sshkit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressLineEvery is how often progress is printed when Client.Progress
+// is not a terminal, for log files and CI output.
+const progressLineEvery = 10 * time.Second
+
+// progress shows how far a transfer has got on a Client's Progress writer:
+// bytes, rate and ETA, redrawn in place on a terminal. Its methods do
+// nothing on a nil *progress, which is what newProgress returns when
+// there is nowhere to show it.
+type progress struct {
+	w      io.Writer
+	tty    bool
+	name   string
+	total  int64 // -1 when unknown
+	offset int64 // resumed from; not counted in the rate
+
+	mu    sync.Mutex
+	done  int64
+	start time.Time
+	drawn time.Time
+}
+
+func (c *Client) newProgress(name string, offset, total int64) *progress {
+	if c.Progress == nil {
+		return nil
+	}
+	p := &progress{w: c.Progress, name: name, total: total, offset: offset, done: offset, start: time.Now()}
+	if f, ok := c.Progress.(*os.File); ok {
+		p.tty = term.IsTerminal(int(f.Fd()))
+	}
+	if offset > 0 {
+		fmt.Fprintf(p.w, "%s: resuming at %s\n", name, formatBytes(offset))
+	}
+	return p
+}
+
+// reader returns r counting what is read through it.
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, p: p}
+}
+
+// writer returns w counting what is written through it.
+func (p *progress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &progressWriter{w: w, p: p}
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(n)
+	return n, err
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(n)
+	return n, err
+}
+
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += int64(n)
+	every := 200 * time.Millisecond
+	if !p.tty {
+		every = progressLineEvery
+	}
+	if now := time.Now(); now.Sub(p.drawn) >= every && now.Sub(p.start) >= every {
+		p.drawn = now
+		p.draw()
+	}
+}
+
+// finish clears the line on a terminal, so the caller's own report of
+// the file takes its place.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty && !p.drawn.IsZero() {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+}
+
+func (p *progress) draw() {
+	line := fmt.Sprintf("%s  %s", p.name, formatBytes(p.done))
+	if p.total > 0 {
+		line += fmt.Sprintf(" / %s  %3d%%", formatBytes(p.total), p.done*100/p.total)
+	}
+	elapsed := time.Since(p.start).Seconds()
+	if rate := float64(p.done-p.offset) / elapsed; elapsed > 0 && rate > 0 {
+		line += fmt.Sprintf("  %s/s", formatBytes(int64(rate)))
+		if p.total > 0 && p.done < p.total {
+			eta := time.Duration(float64(p.total-p.done)/rate) * time.Second
+			line += "  ETA " + eta.Round(time.Second).String()
+		}
+	}
+	if p.tty {
+		fmt.Fprint(p.w, "\r\033[K"+line)
+	} else {
+		fmt.Fprintln(p.w, line)
+	}
+}
+
+// formatBytes formats n with a binary unit: 512 B, 1.5 KiB, 20.0 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,151 @@
+package sshkit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultReconnect is how many times a Client redials a lost connection
+// when neither the Target nor the Dialer says otherwise.
+const DefaultReconnect = 3
+
+// maxReconnectDelay caps the doubling delay between reconnect attempts.
+const maxReconnectDelay = 30 * time.Second
+
+// lostWait is how long a failed operation waits to see whether the
+// connection went down with it, as the transport is torn down a moment
+// after its channels.
+const lostWait = time.Second
+
+// ErrConnectionLost is wrapped by the errors of operations whose
+// connection went down, either while they were running or before they
+// started with reconnects turned off or failing.
+var ErrConnectionLost = errors.New("connection lost")
+
+// watch records when conn, the current connection, goes away, by
+// keepalive failure, the remote end or Close. connMu is held or c not yet
+// shared.
+func (c *Client) watch(conn *ssh.Client) {
+	lost := make(chan struct{})
+	c.lost = lost
+	go func() {
+		conn.Wait()
+		close(lost)
+	}()
+}
+
+// Conn returns the current connection, redialing it first if it was lost
+// (see NewSession). A later reconnect replaces it, so call Conn again
+// rather than keeping it.
+func (c *Client) Conn() (*ssh.Client, error) {
+	conn, _, err := c.conn()
+	return conn, err
+}
+
+// NewSession opens a session like ssh.Client.NewSession. Should the
+// connection have been lost since the last operation, it is first redialed
+// with the Dialer that made it, up to Reconnect times with a doubling
+// delay. Commands are never retried: one that was running when the
+// connection went down fails with ErrConnectionLost.
+func (c *Client) NewSession() (*ssh.Session, error) {
+	session, _, err := c.newSession()
+	return session, err
+}
+
+// newSession is NewSession that also returns the channel closed when the
+// session's connection goes away.
+func (c *Client) newSession() (*ssh.Session, <-chan struct{}, error) {
+	conn, lost, err := c.conn()
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, nil, c.inFlight(lost, err)
+	}
+	return session, lost, nil
+}
+
+// conn returns the connection to use, reconnecting first if it was lost.
+func (c *Client) conn() (*ssh.Client, <-chan struct{}, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.closed || !isDone(c.lost) {
+		return c.sshConn, c.lost, nil
+	}
+	attempts := c.reconnectAttempts()
+	if attempts <= 0 {
+		return nil, nil, fmt.Errorf("%s: %w", c.Target, ErrConnectionLost)
+	}
+	delay := time.Second
+	for i := 1; ; i++ {
+		log.Printf("%s: connection lost, reconnecting (%d/%d)", c.Target, i, attempts)
+		conn, err := c.dialer.Dial(c.Target)
+		if err == nil {
+			c.sshConn = conn
+			c.watch(conn)
+			return conn, c.lost, nil
+		}
+		if i == attempts {
+			return nil, nil, fmt.Errorf("%s: %w, reconnect failed after %d attempts: %v", c.Target, ErrConnectionLost, attempts, err)
+		}
+		time.Sleep(delay)
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// reconnectAttempts is the Target's Reconnect, else the Dialer's, else
+// DefaultReconnect; negative means none.
+func (c *Client) reconnectAttempts() int {
+	if c.dialer == nil {
+		return 0
+	}
+	for _, n := range []int{c.Target.Reconnect, c.dialer.Reconnect} {
+		if n != 0 {
+			return n
+		}
+	}
+	return DefaultReconnect
+}
+
+// inFlight returns err, or ErrConnectionLost wrapping it if the connection
+// behind lost went down with the operation. Only a Client that would
+// reconnect waits lostWait to find out.
+func (c *Client) inFlight(lost <-chan struct{}, err error) error {
+	if lost == nil {
+		return err
+	}
+	gone := isDone(lost)
+	if !gone && c.reconnectAttempts() > 0 {
+		select {
+		case <-lost:
+			gone = true
+		case <-time.After(lostWait):
+		}
+	}
+	if gone {
+		return fmt.Errorf("%w to %s during the operation (it may have completed or still be running on the remote host): %v", ErrConnectionLost, c.Target, err)
+	}
+	return err
+}
+
+// lostChan returns the channel closed when the current connection goes
+// away.
+func (c *Client) lostChan() <-chan struct{} {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.lost
+}
+
+func isDone(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,163 @@
+package sshkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// ErrRolloutStopped is returned by Rollout.Run when it stops before every
+// host has run, because too many failed or the operator said no.
+var ErrRolloutStopped = errors.New("rollout stopped")
+
+// Rollout runs a step on hosts in batches: the hosts of a batch run
+// concurrently, and the next batch starts when the whole batch is done.
+type Rollout struct {
+	// Batch is the batch size, a host count ("2") or a percentage of the
+	// hosts ("20%"); empty or 0 means all hosts in one batch.
+	Batch string
+	// MaxFail stops the rollout once more than this many hosts have
+	// failed; negative never stops.
+	MaxFail int
+	// Confirm asks on the terminal before each batch after the first.
+	Confirm bool
+}
+
+// StepResult is the outcome of the step on one host.
+type StepResult struct {
+	Host    string
+	Err     error
+	Skipped bool // not run because the rollout stopped first
+}
+
+// Status is "ok", "failed" or "skipped".
+func (r StepResult) Status() string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Err != nil:
+		return "failed"
+	}
+	return "ok"
+}
+
+// BatchSize returns the number of hosts per batch for total hosts.
+func (r *Rollout) BatchSize(total int) (int, error) {
+	spec := strings.TrimSpace(r.Batch)
+	if spec == "" || spec == "100%" {
+		return max(total, 1), nil
+	}
+	if pct, ok := strings.CutSuffix(spec, "%"); ok {
+		p, err := strconv.Atoi(pct)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("batch %q: want a percentage from 1%% to 100%%", spec)
+		}
+		return max((total*p+99)/100, 1), nil
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("batch %q: want a host count or a percentage", spec)
+	}
+	if n == 0 {
+		// "0", "00" and "+0" all mean no limit.
+		return max(total, 1), nil
+	}
+	return n, nil
+}
+
+// Run calls step for each host, batch by batch, and returns a result per
+// host in order. It returns ErrRolloutStopped (with the hosts that did
+// not run marked Skipped) when the failure threshold is exceeded or a
+// confirmation is declined.
+func (r *Rollout) Run(hosts []string, step func(i int) error) ([]StepResult, error) {
+	size, err := r.BatchSize(len(hosts))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]StepResult, len(hosts))
+	for i, h := range hosts {
+		results[i] = StepResult{Host: h, Skipped: true}
+	}
+	batches := (len(hosts) + size - 1) / size
+	failed := 0
+	for b := 0; b < batches; b++ {
+		lo, hi := b*size, min((b+1)*size, len(hosts))
+		if b > 0 && r.Confirm {
+			ok, err := confirm(fmt.Sprintf("Run batch %d/%d (%s)? [y/N] ", b+1, batches, strings.Join(hosts[lo:hi], ", ")))
+			if err != nil {
+				return results, err
+			}
+			if !ok {
+				log.Printf("rollout stopped before batch %d/%d", b+1, batches)
+				return results, ErrRolloutStopped
+			}
+		}
+		if batches > 1 {
+			log.Printf("batch %d/%d: %s", b+1, batches, strings.Join(hosts[lo:hi], ", "))
+		}
+
+		var wg sync.WaitGroup
+		for i := lo; i < hi; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = StepResult{Host: hosts[i], Err: step(i)}
+			}()
+		}
+		wg.Wait()
+
+		for i := lo; i < hi; i++ {
+			if results[i].Err != nil {
+				failed++
+			}
+		}
+		if r.MaxFail >= 0 && failed > r.MaxFail && hi < len(hosts) {
+			log.Printf("rollout stopped: %d host(s) failed, more than the %d allowed", failed, r.MaxFail)
+			return results, ErrRolloutStopped
+		}
+	}
+	return results, nil
+}
+
+// WriteRolloutSummary writes one line per host and the totals.
+func WriteRolloutSummary(w io.Writer, results []StepResult) {
+	var ok, failed, skipped int
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "=== summary ===")
+	for _, r := range results {
+		status := r.Status()
+		switch status {
+		case "skipped":
+			skipped++
+		case "failed":
+			status += ": " + r.Err.Error()
+			failed++
+		default:
+			ok++
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", r.Host, status)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d hosts: %d ok, %d failed, %d skipped\n", len(results), ok, failed, skipped)
+}
+
+// confirm asks a yes/no question on the terminal.
+func confirm(question string) (bool, error) {
+	if !canPrompt() {
+		return false, errors.New("confirmation between batches needs a terminal")
+	}
+	answer, err := readLine(question)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,52 @@
+package sshkit
+
+import "testing"
+
+func TestBatchSize(t *testing.T) {
+	tests := []struct {
+		batch string
+		total int
+		want  int
+		err   bool
+	}{
+		{"", 5, 5, false},
+		{"", 0, 1, false},
+		{"0", 5, 5, false},
+		{"00", 5, 5, false},
+		{"+0", 5, 5, false},
+		{" 0", 5, 5, false},
+		{"0", 0, 1, false},
+		{"2", 5, 2, false},
+		{"7", 5, 7, false},
+		{"100%", 5, 5, false},
+		{"20%", 5, 1, false},
+		{"50%", 5, 3, false},
+		{"1%", 1000, 10, false},
+		{"-1", 5, 0, true},
+		{"0%", 5, 0, true},
+		{"101%", 5, 0, true},
+		{"x", 5, 0, true},
+	}
+	for _, tt := range tests {
+		r := &Rollout{Batch: tt.batch}
+		got, err := r.BatchSize(tt.total)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("Batch %q of %d = %d, %v; want %d, error %v", tt.batch, tt.total, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRunZeroBatch(t *testing.T) {
+	for _, batch := range []string{"0", "00", "+0"} {
+		r := &Rollout{Batch: batch, MaxFail: -1}
+		results, err := r.Run([]string{"a", "b", "c"}, func(int) error { return nil })
+		if err != nil {
+			t.Fatalf("Batch %q: %v", batch, err)
+		}
+		for _, res := range results {
+			if res.Status() != "ok" {
+				t.Errorf("Batch %q: %s is %s", batch, res.Host, res.Status())
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package sshkit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Shell runs an interactive session on the local terminal: a login shell,
+// or cmd (e.g. "top") when it is not empty. A PTY is requested with the
+// terminal's size and $TERM, the local terminal is put in raw mode and
+// restored on return, and size changes are sent as window-change
+// requests. It returns the remote exit status.
+func (c *Client) Shell(cmd string) (int, error) {
+	session, err := c.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("new session: %w", err)
+	}
+	defer session.Close()
+
+	inFd, outFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	width, height := 80, 24
+	if w, h, err := term.GetSize(outFd); err == nil {
+		width, height = w, h
+	}
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return -1, fmt.Errorf("request pty: %w", err)
+	}
+
+	if term.IsTerminal(inFd) {
+		state, err := term.MakeRaw(inFd)
+		if err != nil {
+			return -1, fmt.Errorf("raw mode: %w", err)
+		}
+		defer term.Restore(inFd, state)
+	}
+	stopResize := watchWindowSize(session, outFd)
+	defer stopResize()
+
+	// In raw mode ^C goes to the remote side; a SIGTERM or SIGHUP aimed at
+	// us ends the session so the terminal is still restored.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			session.Close()
+		}
+	}()
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	if cmd == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(cmd)
+	}
+	if err != nil {
+		return -1, fmt.Errorf("start: %w", err)
+	}
+
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		return -1, fmt.Errorf("session: %w", err)
+	}
+}
@@ -0,0 +1,149 @@
+package sshkit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// DefaultTail is how much of each stream Run and Stream keep for the
+// Result and for error reports.
+const DefaultTail = 16 << 10
+
+// Tail is an io.Writer that keeps only the last Max bytes written to it.
+type Tail struct {
+	Max       int
+	buf       []byte
+	truncated bool
+}
+
+// NewTail returns a Tail keeping max bytes.
+func NewTail(max int) *Tail {
+	return &Tail{Max: max}
+}
+
+func (t *Tail) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= t.Max {
+		t.truncated = t.truncated || len(t.buf) > 0 || len(p) > t.Max
+		t.buf = append(t.buf[:0], p[len(p)-t.Max:]...)
+		return n, nil
+	}
+	if over := len(t.buf) + len(p) - t.Max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+// String returns the kept bytes, starting at a line boundary when earlier
+// output was dropped.
+func (t *Tail) String() string {
+	b := t.buf
+	if t.truncated {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 && i < len(b)-1 {
+			b = b[i+1:]
+		}
+	}
+	return string(b)
+}
+
+// Truncated reports whether output was dropped from the front.
+func (t *Tail) Truncated() bool {
+	return t.truncated
+}
+
+// PrefixWriter writes each line to W with Prefix in front, holding back a
+// partial line until its newline arrives or Flush is called. Whole lines
+// go to W in a single Write, so writers sharing W interleave by line.
+type PrefixWriter struct {
+	W      io.Writer
+	Prefix string
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// NewPrefixWriter returns a PrefixWriter writing to w.
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{W: w, Prefix: prefix}
+}
+
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.partial = append(p.partial, b...)
+			break
+		}
+		line := make([]byte, 0, len(p.Prefix)+len(p.partial)+i+1)
+		line = append(line, p.Prefix...)
+		line = append(line, p.partial...)
+		line = append(line, b[:i+1]...)
+		p.partial = p.partial[:0]
+		b = b[i+1:]
+		if _, err := p.W.Write(line); err != nil {
+			return n - len(b), err
+		}
+	}
+	return n, nil
+}
+
+// Flush writes out a pending partial line, terminated with a newline.
+func (p *PrefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) == 0 {
+		return nil
+	}
+	line := append([]byte(p.Prefix), p.partial...)
+	p.partial = p.partial[:0]
+	_, err := p.W.Write(append(line, '\n'))
+	return err
+}
+
+// StreamContext runs cmd with its output copied live to stdout and stderr
+// (either may be nil) while the last tail bytes of each are kept in the
+// Result. Cancellation works as for ExecContext; the Result is returned
+// with the error in that case too.
+func (c *Client) StreamContext(ctx context.Context, cmd string, stdout, stderr io.Writer, tail int) (*Result, error) {
+	return c.stream(ctx, cmd, nil, nil, stdout, stderr, tail)
+}
+
+// StreamCommand is StreamContext for a built command, with its Env and
+// stdin handled as by ExecCommand.
+func (c *Client) StreamCommand(ctx context.Context, cmd *Command, stdin io.Reader, stdout, stderr io.Writer, tail int) (*Result, error) {
+	return withEnv(cmd, func(line string, env []string) (*Result, error) {
+		return c.stream(ctx, line, env, stdin, stdout, stderr, tail)
+	})
+}
+
+func (c *Client) stream(ctx context.Context, cmd string, env []string, stdin io.Reader, stdout, stderr io.Writer, tail int) (*Result, error) {
+	if tail <= 0 {
+		tail = DefaultTail
+	}
+	outTail, errTail := NewTail(tail), NewTail(tail)
+	code, err := c.run(ctx, cmd, env, stdin, tee(outTail, stdout), tee(errTail, stderr))
+	for _, w := range []io.Writer{stdout, stderr} {
+		if f, ok := w.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	res := &Result{ExitCode: code, Stdout: outTail.String(), Stderr: errTail.String()}
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return res, err
+}
+
+func tee(t *Tail, w io.Writer) io.Writer {
+	if w == nil {
+		return t
+	}
+	return io.MultiWriter(t, w)
+}
@@ -0,0 +1,97 @@
+package sshkit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"one line", []string{"hello\n"}, "[h] hello\n"},
+		{"several lines", []string{"a\nb\n"}, "[h] a\n[h] b\n"},
+		{"line across writes", []string{"hel", "lo", "\n"}, "[h] hello\n"},
+		{"newline starts a write", []string{"a", "\nb\n"}, "[h] a\n[h] b\n"},
+		{"empty line", []string{"\n"}, "[h] \n"},
+		{"partial last line", []string{"a\nb"}, "[h] a\n[h] b\n"},
+		{"partial across writes", []string{"a\nb", "c"}, "[h] a\n[h] bc\n"},
+		{"nothing", nil, ""},
+		{"empty write", []string{""}, ""},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		p := NewPrefixWriter(&out, "[h] ")
+		for _, w := range tt.writes {
+			if n, err := p.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", tt.name, w, n, err)
+			}
+		}
+		if err := p.Flush(); err != nil {
+			t.Errorf("%s: Flush: %v", tt.name, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+// TestPrefixWriterWholeLines checks that every Write to W is a whole line,
+// so writers sharing W interleave by line.
+func TestPrefixWriterWholeLines(t *testing.T) {
+	var lines []string
+	w := writerFunc(func(b []byte) (int, error) {
+		lines = append(lines, string(b))
+		return len(b), nil
+	})
+	p := NewPrefixWriter(w, "> ")
+	for _, s := range []string{"a", "b\nc", "\nd\ne", "\n"} {
+		p.Write([]byte(s))
+	}
+	want := []string{"> ab\n", "> c\n", "> d\n", "> e\n"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("writes %q, want %q", lines, want)
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		writes    []string
+		want      string
+		truncated bool
+	}{
+		{"fits", 10, []string{"abc", "def"}, "abcdef", false},
+		{"exactly max", 6, []string{"abc", "def"}, "abcdef", false},
+		{"one write exactly max", 3, []string{"abc"}, "abc", false},
+		{"drops the front", 4, []string{"abc", "def"}, "cdef", true},
+		{"one write over max", 3, []string{"abcdef"}, "def", true},
+		{"write of max after output", 3, []string{"x", "abc"}, "abc", true},
+		{"starts at a line", 8, []string{"one\ntwo\nthree\n"}, "three\n", true},
+		{"no newline kept", 4, []string{"line\nabcdef"}, "cdef", true},
+		{"newline at the end only", 4, []string{"abcdef\n"}, "def\n", true},
+		{"lines not truncated", 20, []string{"one\ntwo\n"}, "one\ntwo\n", false},
+	}
+	for _, tt := range tests {
+		tail := NewTail(tt.max)
+		for _, w := range tt.writes {
+			if n, err := tail.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", tt.name, w, n, err)
+			}
+		}
+		if got := tail.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if tail.Truncated() != tt.truncated {
+			t.Errorf("%s: Truncated() = %v, want %v", tt.name, tail.Truncated(), tt.truncated)
+		}
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
@@ -0,0 +1,222 @@
+package sshkit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SudoMethod is how AsRoot gains root.
+type SudoMethod string
+
+const (
+	Sudo SudoMethod = "sudo"
+	Doas SudoMethod = "doas"
+	Su   SudoMethod = "su"
+)
+
+// ParseSudoMethod checks a -sudo-method style setting; empty means Sudo.
+func ParseSudoMethod(s string) (SudoMethod, error) {
+	switch m := SudoMethod(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return Sudo, nil
+	case Sudo, Doas, Su:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown sudo method %q (want sudo, doas or su)", s)
+}
+
+// sudoPrompt is the prompt AsRoot gives sudo, so that exec can tell the
+// password request apart from the command's own output.
+const sudoPrompt = "[sshkit:sudo-password]"
+
+// AsRoot returns cmd wrapped to run as root with SudoMethod when UseSudo
+// is set, and unchanged otherwise. The password is never part of the
+// command: when SudoPassword is set, Exec, Output and Run write it to the
+// session's stdin if and only if the wrapper prompts for it, so hosts with
+// NOPASSWD rules or cached credentials never receive it. Without a
+// password sudo and doas run non-interactively (-n) and fail instead of
+// waiting for one.
+//
+// The password is given at most once, and only before the command's own
+// output starts, so a command's own "New password:" prompt is never
+// answered. The command reads /dev/null rather than the session's stdin,
+// so one that reads stdin gets end of file instead of waiting, even when
+// no prompt comes and nothing closes stdin. su and doas read the password
+// from a terminal, so commands containing their wrapper run on a PTY,
+// where stderr is merged into stdout.
+func (c *Client) AsRoot(cmd string) string {
+	if !c.UseSudo {
+		return cmd
+	}
+	if c.SudoPassword == "" {
+		switch c.SudoMethod {
+		case Doas:
+			return "doas -n sh -c " + Quote(cmd)
+		case Su:
+			return "su root -c " + Quote(cmd)
+		}
+		return "sudo -n sh -c " + Quote(cmd)
+	}
+	// stdin is left to the prompt: it stays open while nothing asks for
+	// the password, and closing it does not reach a command on a PTY.
+	return c.rootPrefix() + Quote("exec </dev/null; "+cmd)
+}
+
+// rootPrefix is what AsRoot puts in front of a command when it has a
+// password to answer with.
+func (c *Client) rootPrefix() string {
+	switch c.SudoMethod {
+	case Doas:
+		return "doas sh -c "
+	case Su:
+		return "su root -c "
+	}
+	return "sudo -S -p " + Quote(sudoPrompt) + " sh -c "
+}
+
+// answerPrompts prepares session to answer the password prompts of the
+// AsRoot wrappers in cmd. It returns the writers to use in place of stdout
+// and stderr, and a function that writes out any output they held back,
+// to call once the command has finished.
+func (c *Client) answerPrompts(session *ssh.Session, cmd string, stdout, stderr io.Writer) (io.Writer, io.Writer, func(), error) {
+	if !c.UseSudo || c.SudoPassword == "" || !strings.Contains(cmd, c.rootPrefix()) {
+		return stdout, stderr, func() {}, nil
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("stdin: %w", err)
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	if c.SudoMethod != Doas && c.SudoMethod != Su {
+		// sudo -S prompts on stderr with our marker.
+		r := &promptResponder{W: stderr, stdin: stdin, answer: c.SudoPassword, match: func(pending string) (string, bool) {
+			before, after, ok := strings.Cut(pending, sudoPrompt)
+			return before + after, ok
+		}}
+		// sudo may lecture on stderr before prompting, but once the
+		// command writes to stdout it is past the prompt.
+		return &onFirstWrite{W: stdout, fn: r.stop}, r, r.flush, nil
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:  0,
+		ssh.ONLCR: 0,
+	}
+	if err := session.RequestPty("dumb", 24, 200, modes); err != nil {
+		return nil, nil, nil, fmt.Errorf("request pty: %w", err)
+	}
+	r := &promptResponder{W: stdout, stdin: stdin, answer: c.SudoPassword, promptFirst: true, match: func(pending string) (string, bool) {
+		// The prompt is the unfinished first line: "Password: " from su,
+		// "doas (user@host) password: " from doas.
+		prompt := strings.TrimSpace(pending)
+		if strings.Contains(prompt, "\n") || !strings.HasSuffix(prompt, ":") || !strings.Contains(strings.ToLower(prompt), "password") {
+			return "", false
+		}
+		if c.SudoMethod == Doas && !strings.HasPrefix(prompt, "doas (") {
+			return "", false
+		}
+		return "", true
+	}}
+	return r, stderr, r.flush, nil
+}
+
+// promptResponder copies output to W, holding back a partial line until
+// its newline arrives. When match finds a password prompt in the pending
+// output, the output is replaced by what match returns, without the
+// prompt, answer is written to stdin and stdin is closed. That happens at
+// most once, and not at all after stop, or with promptFirst once a whole
+// line has gone by, as the wrapper prompts before the command runs.
+// Should a terminal echo the answer back anyway, that line is dropped.
+type promptResponder struct {
+	W           io.Writer
+	stdin       io.WriteCloser
+	answer      string
+	match       func(pending string) (rest string, ok bool)
+	promptFirst bool
+
+	mu      sync.Mutex
+	partial []byte
+	done    bool // answered or stopped: no more matching
+	echoed  bool // the next line may be the echoed answer
+}
+
+func (p *promptResponder) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	if !p.done {
+		if rest, ok := p.match(string(p.partial)); ok {
+			p.partial = append(p.partial[:0], rest...)
+			p.done, p.echoed = true, true
+			_, err := io.WriteString(p.stdin, p.answer+"\n")
+			p.stdin.Close()
+			if err != nil {
+				return 0, fmt.Errorf("answer password prompt: %w", err)
+			}
+		}
+	}
+	if i := bytes.LastIndexByte(p.partial, '\n'); i >= 0 {
+		lines := p.partial[:i+1]
+		if p.echoed {
+			first, rest, _ := bytes.Cut(lines, []byte("\n"))
+			if string(bytes.TrimSuffix(first, []byte("\r"))) == p.answer {
+				lines = rest
+			}
+			p.echoed = false
+		}
+		if p.promptFirst {
+			p.stopLocked()
+		}
+		if _, err := p.W.Write(lines); err != nil {
+			return 0, err
+		}
+		p.partial = append(p.partial[:0], p.partial[i+1:]...)
+	}
+	return len(b), nil
+}
+
+// stop ends matching, and closes stdin if no answer went to it.
+func (p *promptResponder) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopLocked()
+}
+
+func (p *promptResponder) stopLocked() {
+	if !p.done {
+		p.done = true
+		p.stdin.Close()
+	}
+}
+
+// onFirstWrite is W that calls fn before the first write to it.
+type onFirstWrite struct {
+	W    io.Writer
+	fn   func()
+	once sync.Once
+}
+
+func (w *onFirstWrite) Write(b []byte) (int, error) {
+	w.once.Do(w.fn)
+	return w.W.Write(b)
+}
+
+func (p *promptResponder) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) > 0 {
+		p.W.Write(p.partial)
+		p.partial = p.partial[:0]
+	}
+}
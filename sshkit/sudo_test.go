@@ -0,0 +1,70 @@
+package sshkit
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAsRootNoPrompt runs AsRoot wrappers through stand-ins for sudo, doas
+// and su that never prompt, as on a NOPASSWD host, with a stdin that is
+// never closed: a command reading stdin must still finish.
+func TestAsRootNoPrompt(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	bin := t.TempDir()
+	fakes := map[string]string{
+		// Skip -S, -n and -p PROMPT, then run the rest.
+		"sudo": `while [ "${1#-}" != "$1" ]; do [ "$1" = -p ] && shift; shift; done; exec "$@"`,
+		"doas": `[ "$1" = -n ] && shift; exec "$@"`,
+		"su":   `exec sh -c "$3"`,
+	}
+	for name, body := range fakes {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		method SudoMethod
+		cmd    string
+		want   string
+	}{
+		{Sudo, "cat; echo done", "done\n"},
+		{Sudo, "read line || echo eof >&2", "eof\n"},
+		{Doas, "cat; echo done", "done\n"},
+		{Su, "cat; echo done", "done\n"},
+	}
+	for _, tt := range tests {
+		c := &Client{UseSudo: true, SudoMethod: tt.method, SudoPassword: "secret"}
+		cmd := exec.Command("sh", "-c", c.AsRoot(tt.cmd))
+		stdin, err := cmd.StdinPipe() // held open, like a session's stdin
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stdin.Close()
+		var out bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &out, &out
+		cmd.WaitDelay = time.Second // a killed sh may leave the command holding stdout
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil || out.String() != tt.want {
+				t.Errorf("%s %q: %q, %v; want %q", tt.method, tt.cmd, out.String(), err, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			<-done
+			t.Errorf("%s %q: still waiting for stdin", tt.method, tt.cmd)
+		}
+	}
+}
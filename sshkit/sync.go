@@ -0,0 +1,455 @@
+package sshkit
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncOptions changes what Sync compares and does.
+type SyncOptions struct {
+	// Checksum compares files of the same size by SHA-256 instead of by
+	// modification time.
+	Checksum bool
+	// Delete removes remote files and directories missing from the local
+	// tree.
+	Delete bool
+	// DryRun works out the changes without making them.
+	DryRun bool
+}
+
+// SyncChange is a path that Sync sends or deletes.
+type SyncChange struct {
+	Path   string      // relative to both trees, slash-separated
+	Mode   os.FileMode // of the local path, or of the remote one for a delete
+	Size   int64
+	Delete bool
+	// Reason says why a path is sent: new, size, mtime, checksum, mode,
+	// type (a remote path of another kind is replaced) or link (a symlink
+	// points elsewhere).
+	Reason string
+}
+
+// Sync makes the remote directory remoteDir match the local directory
+// localDir. It lists the remote tree with one command and compares each
+// file by size and modification time (or SHA-256, see SyncOptions), then
+// sends every changed file, directory and symlink as one tar stream into
+// tar -x on the remote host, which keeps permission bits and modification
+// times and creates remoteDir if needed. A remote path whose kind differs
+// from the local one is removed first, contents and all; other remote
+// paths are only removed with SyncOptions.Delete, once the rest is sent.
+// Local sockets, devices and pipes are left out.
+//
+// The changes are returned sorted by path, also when making them failed.
+func (c *Client) Sync(localDir, remoteDir string, opts SyncOptions) ([]SyncChange, error) {
+	local, err := localTree(localDir)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := c.remoteTree(remoteDir)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", remoteDir, err)
+	}
+	changes, err := c.syncChanges(localDir, remoteDir, local, remote, opts)
+	if err != nil || opts.DryRun {
+		return changes, err
+	}
+
+	var replaced, deleted []string
+	var sends []SyncChange
+	for _, ch := range changes {
+		switch {
+		case ch.Delete:
+			deleted = append(deleted, ch.Path)
+		case ch.Reason == "type":
+			replaced = append(replaced, ch.Path)
+			fallthrough
+		default:
+			sends = append(sends, ch)
+		}
+	}
+	if len(replaced) > 0 {
+		if err := c.removeRemote(remoteDir, replaced); err != nil {
+			return changes, err
+		}
+	}
+	if len(sends) > 0 {
+		if err := c.sendTar(localDir, remoteDir, sends); err != nil {
+			return changes, err
+		}
+	}
+	if len(deleted) > 0 {
+		if err := c.removeRemote(remoteDir, deleted); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// treeEntry is a file, directory or symlink under a synced directory.
+type treeEntry struct {
+	path  string // relative, slash-separated
+	mode  os.FileMode
+	size  int64
+	mtime int64 // Unix seconds
+	link  string
+}
+
+func localTree(dir string) ([]treeEntry, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+	// Walk through a symlink to a directory.
+	root := dir + string(filepath.Separator)
+	var entries []treeEntry
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if rel == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := treeEntry{path: filepath.ToSlash(rel), mode: info.Mode(), mtime: info.ModTime().Unix()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if e.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			e.size = info.Size()
+		case !info.IsDir():
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// remoteListScript lists the tree under $1, if it exists, as records of
+// type, size, mtime, mode and path, then the symlink target, each ended by
+// a NUL. It exits 3 when find has no -printf (BusyBox, BSD).
+const remoteListScript = `[ -d "$1" ] || exit 0
+cd "$1" || exit 1
+find . -maxdepth 0 -printf '' 2>/dev/null || exit 3
+exec find . -mindepth 1 -printf '%y %s %T@ %m %P\0%l\0'`
+
+// remoteTree lists the tree under dir, which may not exist, with find or,
+// where find cannot print the details, by walking it over SFTP.
+func (c *Client) remoteTree(dir string) ([]treeEntry, error) {
+	out, err := c.Output(Script(remoteListScript, dir).String())
+	var ce *CommandError
+	if errors.As(err, &ce) && ce.ExitCode == 3 {
+		return c.sftpTree(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRemoteTree(out)
+}
+
+// parseRemoteTree reads the records printed by remoteListScript.
+func parseRemoteTree(out string) ([]treeEntry, error) {
+	fields := strings.Split(out, "\x00")
+	var entries []treeEntry
+	for i := 0; i+1 < len(fields); i += 2 {
+		rec := strings.SplitN(fields[i], " ", 5)
+		if len(rec) != 5 {
+			return nil, fmt.Errorf("unexpected find output %q", fields[i])
+		}
+		size, _ := strconv.ParseInt(rec[1], 10, 64)
+		secs, _, _ := strings.Cut(rec[2], ".")
+		mtime, _ := strconv.ParseInt(secs, 10, 64)
+		perm, _ := strconv.ParseUint(rec[3], 8, 32)
+		e := treeEntry{path: rec[4], mode: os.FileMode(perm) & fs.ModePerm, mtime: mtime}
+		switch rec[0] {
+		case "f":
+			e.size = size
+		case "d":
+			e.mode |= fs.ModeDir
+		case "l":
+			e.mode |= fs.ModeSymlink
+			e.link = fields[i+1]
+		default:
+			e.mode |= fs.ModeIrregular
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (c *Client) sftpTree(dir string) ([]treeEntry, error) {
+	s, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	w := s.Walk(dir)
+	for w.Step() {
+		rel := relPath(dir, w.Path())
+		if err := w.Err(); err != nil {
+			if rel == "" && errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if rel == "" {
+			continue
+		}
+		info := w.Stat()
+		e := treeEntry{path: rel, mode: info.Mode(), mtime: info.ModTime().Unix()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if e.link, err = s.ReadLink(w.Path()); err != nil {
+				return nil, err
+			}
+		case info.Mode().IsRegular():
+			e.size = info.Size()
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// syncChanges compares the trees, hashing files on both sides when
+// opts.Checksum asks for it.
+func (c *Client) syncChanges(localDir, remoteDir string, local, remote []treeEntry, opts SyncOptions) ([]SyncChange, error) {
+	remoteByPath := make(map[string]treeEntry, len(remote))
+	for _, r := range remote {
+		remoteByPath[r.path] = r
+	}
+	var changes []SyncChange
+	var hash []treeEntry
+	for _, l := range local {
+		reason := ""
+		r, ok := remoteByPath[l.path]
+		switch {
+		case !ok:
+			reason = "new"
+		case l.mode.Type() != r.mode.Type():
+			reason = "type"
+		case l.mode&fs.ModeSymlink != 0:
+			if l.link != r.link {
+				reason = "link"
+			}
+		case l.mode.Perm() != r.mode.Perm():
+			reason = "mode"
+		case l.mode.IsDir():
+		case l.size != r.size:
+			reason = "size"
+		case opts.Checksum:
+			hash = append(hash, l)
+		case l.mtime != r.mtime:
+			reason = "mtime"
+		}
+		if reason != "" {
+			changes = append(changes, SyncChange{Path: l.path, Mode: l.mode, Size: l.size, Reason: reason})
+		}
+	}
+
+	if len(hash) > 0 {
+		paths := make([]string, len(hash))
+		for i, l := range hash {
+			paths[i] = l.path
+		}
+		sums, err := c.remoteHashes(remoteDir, paths)
+		if err != nil {
+			return nil, err
+		}
+		for i, l := range hash {
+			sum, err := FileSHA256(filepath.Join(localDir, filepath.FromSlash(l.path)))
+			if err != nil {
+				return nil, err
+			}
+			if sum != sums[i] {
+				changes = append(changes, SyncChange{Path: l.path, Mode: l.mode, Size: l.size, Reason: "checksum"})
+			}
+		}
+	}
+
+	if opts.Delete {
+		inLocal := make(map[string]bool, len(local))
+		for _, l := range local {
+			inLocal[l.path] = true
+		}
+		gone := make(map[string]bool)
+		for _, ch := range changes {
+			if ch.Reason == "type" {
+				gone[ch.Path] = true
+			}
+		}
+		for _, r := range remote {
+			if inLocal[r.path] || underAny(r.path, gone) {
+				continue
+			}
+			gone[r.path] = true
+			changes = append(changes, SyncChange{Path: r.path, Mode: r.mode, Size: r.size, Delete: true})
+		}
+	}
+
+	// Sorted, a directory comes before what is in it, as tar needs.
+	slices.SortFunc(changes, func(a, b SyncChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
+
+// underAny reports whether p is inside one of the directories in dirs.
+func underAny(p string, dirs map[string]bool) bool {
+	for d := path.Dir(p); d != "."; d = path.Dir(d) {
+		if dirs[d] {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHashListScript prints the SHA-256 of each file under $1 named on
+// its standard input, one per line, or "-" for one it cannot read.
+const remoteHashListScript = sha256Func + `cd "$1" || exit 1
+while IFS= read -r f; do { h < "$f" || echo -; } 2>/dev/null; done`
+
+// remoteHashes returns the hex SHA-256 of each of paths under dir, or ""
+// for one that could not be hashed.
+func (c *Client) remoteHashes(dir string, paths []string) ([]string, error) {
+	var in strings.Builder
+	var listed []int
+	for i, p := range paths {
+		// A name with a newline cannot be listed; it counts as changed.
+		if !strings.Contains(p, "\n") {
+			in.WriteString(p + "\n")
+			listed = append(listed, i)
+		}
+	}
+	out, err := c.runInput(Script(remoteHashListScript, dir), strings.NewReader(in.String()))
+	if err != nil {
+		return nil, fmt.Errorf("sha256 in %s: %w", dir, err)
+	}
+	sums := make([]string, len(paths))
+	for j, line := range strings.Split(out, "\n") {
+		if f := strings.Fields(line); j < len(listed) && len(f) > 0 && len(f[0]) == sha256.Size*2 {
+			sums[listed[j]] = strings.ToLower(f[0])
+		}
+	}
+	return sums, nil
+}
+
+// syncTarScript extracts the tar stream on its standard input into $1,
+// creating it if needed, keeping the archive's permissions and times but
+// not its owners.
+const syncTarScript = `mkdir -p "$1" && cd "$1" && exec tar -xopf -`
+
+// sendTar streams the local files of changes, under localDir, into
+// syncTarScript.
+func (c *Client) sendTar(localDir, remoteDir string, changes []SyncChange) error {
+	var total int64
+	for _, ch := range changes {
+		total += ch.Size
+	}
+	p := c.newProgress(path.Base(remoteDir), 0, total)
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := writeTar(pw, localDir, changes, p)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	_, err := c.runInput(Script(syncTarScript, remoteDir), pr)
+	// Unblock writeTar if tar stopped reading.
+	pr.CloseWithError(io.ErrClosedPipe)
+	werr := <-written
+	p.finish()
+	if werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		return fmt.Errorf("tar: %w", werr)
+	}
+	if err != nil {
+		return fmt.Errorf("tar -x: %w", err)
+	}
+	return nil
+}
+
+func writeTar(w io.Writer, localDir string, changes []SyncChange, p *progress) error {
+	tw := tar.NewWriter(w)
+	for _, ch := range changes {
+		name := filepath.Join(localDir, filepath.FromSlash(ch.Path))
+		info, err := os.Lstat(name)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(name); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		hdr.Name = ch.Path
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		hdr.ModTime = info.ModTime().Truncate(time.Second)
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(tw, p.reader(f), hdr.Size)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// removeRemoteScript removes the paths under $1 named on its standard
+// input, each ended by a NUL.
+const removeRemoteScript = `cd "$1" && xargs -0 rm -rf --`
+
+func (c *Client) removeRemote(dir string, paths []string) error {
+	in := strings.Join(paths, "\x00") + "\x00"
+	if _, err := c.runInput(Script(removeRemoteScript, dir), strings.NewReader(in)); err != nil {
+		return fmt.Errorf("remove in %s: %w", dir, err)
+	}
+	return nil
+}
+
+// runInput runs cmd with stdin as its standard input and returns its
+// stdout, turning a non-zero exit into a *CommandError.
+func (c *Client) runInput(cmd *Command, stdin io.Reader) (string, error) {
+	res, err := c.ExecCommand(context.Background(), cmd, stdin)
+	if err != nil {
+		return "", err
+	}
+	if res.ExitCode != 0 {
+		return res.Stdout, &CommandError{Cmd: cmd.String(), ExitCode: res.ExitCode, Stdout: res.Stdout, Stderr: res.Stderr}
+	}
+	return res.Stdout, nil
+}
@@ -0,0 +1,156 @@
+package sshkit
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRemoteTree(t *testing.T) {
+	out := "d 4096 1700000000.1234567890 755 conf\x00\x00" +
+		"f 12 1700000001.0000000000 644 conf/app.ini\x00\x00" +
+		"f 3 1700000002.5000000000 600 my file.txt\x00\x00" +
+		"f 5 1700000003.0000000000 644 two\nlines\x00\x00" +
+		"l 7 1700000004.0000000000 777 current\x00releases/v2 beta\x00" +
+		"s 0 1700000005.0000000000 755 run.sock\x00\x00"
+	want := []treeEntry{
+		{path: "conf", mode: fs.ModeDir | 0o755, mtime: 1700000000},
+		{path: "conf/app.ini", mode: 0o644, size: 12, mtime: 1700000001},
+		{path: "my file.txt", mode: 0o600, size: 3, mtime: 1700000002},
+		{path: "two\nlines", mode: 0o644, size: 5, mtime: 1700000003},
+		{path: "current", mode: fs.ModeSymlink | 0o777, mtime: 1700000004, link: "releases/v2 beta"},
+		{path: "run.sock", mode: fs.ModeIrregular | 0o755, mtime: 1700000005},
+	}
+	got, err := parseRemoteTree(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n  %+v\nwant\n  %+v", got, want)
+	}
+
+	if got, err := parseRemoteTree(""); err != nil || len(got) != 0 {
+		t.Errorf("parseRemoteTree(\"\") = %v, %v; want nothing", got, err)
+	}
+	if _, err := parseRemoteTree("f 12 1700000001.0 644\x00\x00"); err == nil {
+		t.Error("record without a path: no error")
+	}
+}
+
+// TestRemoteListScript runs remoteListScript on a local directory and
+// checks that it lists what localTree does, names with spaces and newlines
+// included.
+func TestRemoteListScript(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	for name, data := range map[string]string{"a file": "x", "new\nline": "yz", "sub/deep": "123"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a file", filepath.Join(dir, "link to")); err != nil {
+		t.Skip(err)
+	}
+	out, err := exec.Command("sh", "-c", remoteListScript, "sh", dir).Output()
+	if err != nil {
+		t.Skipf("no find with -printf here: %v", err)
+	}
+	got, err := parseRemoteTree(string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := localTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := func(entries []treeEntry) map[string]treeEntry {
+		m := make(map[string]treeEntry)
+		for _, e := range entries {
+			if e.mode&fs.ModeSymlink != 0 {
+				e.mode = fs.ModeSymlink // permissions of a link vary
+			}
+			m[e.path] = e
+		}
+		return m
+	}
+	if !reflect.DeepEqual(byPath(got), byPath(want)) {
+		t.Errorf("find listed\n  %+v\nlocalTree has\n  %+v", got, want)
+	}
+}
+
+func TestSyncChanges(t *testing.T) {
+	file := func(p string, mode os.FileMode, size, mtime int64) treeEntry {
+		return treeEntry{path: p, mode: mode, size: size, mtime: mtime}
+	}
+	dir := func(p string) treeEntry {
+		return treeEntry{path: p, mode: fs.ModeDir | 0o755, mtime: 1}
+	}
+	link := func(p, target string) treeEntry {
+		return treeEntry{path: p, mode: fs.ModeSymlink | 0o777, link: target}
+	}
+	tests := []struct {
+		name          string
+		local, remote []treeEntry
+		delete        bool
+		want          []SyncChange
+	}{
+		{"same", []treeEntry{file("a", 0o644, 1, 5)}, []treeEntry{file("a", 0o644, 1, 5)}, false, nil},
+		{"new", []treeEntry{file("a", 0o644, 1, 5)}, nil, false,
+			[]SyncChange{{Path: "a", Mode: 0o644, Size: 1, Reason: "new"}}},
+		{"size", []treeEntry{file("a", 0o644, 2, 5)}, []treeEntry{file("a", 0o644, 1, 5)}, false,
+			[]SyncChange{{Path: "a", Mode: 0o644, Size: 2, Reason: "size"}}},
+		{"mtime", []treeEntry{file("a", 0o644, 1, 6)}, []treeEntry{file("a", 0o644, 1, 5)}, false,
+			[]SyncChange{{Path: "a", Mode: 0o644, Size: 1, Reason: "mtime"}}},
+		{"mode only", []treeEntry{file("run.sh", 0o755, 1, 5)}, []treeEntry{file("run.sh", 0o644, 1, 5)}, false,
+			[]SyncChange{{Path: "run.sh", Mode: 0o755, Size: 1, Reason: "mode"}}},
+		{"directory mode only", []treeEntry{{path: "d", mode: fs.ModeDir | 0o700}}, []treeEntry{dir("d")}, false,
+			[]SyncChange{{Path: "d", Mode: fs.ModeDir | 0o700, Reason: "mode"}}},
+		{"directory mtime ignored", []treeEntry{{path: "d", mode: fs.ModeDir | 0o755, mtime: 9}}, []treeEntry{dir("d")}, false, nil},
+		{"link target", []treeEntry{link("cur", "v2")}, []treeEntry{link("cur", "v1")}, false,
+			[]SyncChange{{Path: "cur", Mode: fs.ModeSymlink | 0o777, Reason: "link"}}},
+		{"type", []treeEntry{file("x", 0o644, 1, 5)}, []treeEntry{dir("x")}, false,
+			[]SyncChange{{Path: "x", Mode: 0o644, Size: 1, Reason: "type"}}},
+		{"extra remote kept", nil, []treeEntry{file("old", 0o644, 1, 5)}, false, nil},
+		{"deletion", nil, []treeEntry{file("old", 0o644, 1, 5)}, true,
+			[]SyncChange{{Path: "old", Mode: 0o644, Size: 1, Delete: true}}},
+		{"deleted directory, not its contents", nil, []treeEntry{dir("d"), file("d/a", 0o644, 1, 5), file("d/b", 0o644, 2, 5)}, true,
+			[]SyncChange{{Path: "d", Mode: fs.ModeDir | 0o755, Delete: true}}},
+		{"contents of a replaced directory", []treeEntry{file("d", 0o644, 1, 5)}, []treeEntry{dir("d"), file("d/a", 0o644, 1, 5)}, true,
+			[]SyncChange{{Path: "d", Mode: 0o644, Size: 1, Reason: "type"}}},
+		{"names with spaces and newlines",
+			[]treeEntry{file("my file", 0o644, 1, 5), file("two\nlines", 0o644, 2, 5)},
+			[]treeEntry{file("my file", 0o644, 1, 4), file("old\nname", 0o644, 1, 5)}, true,
+			[]SyncChange{
+				{Path: "my file", Mode: 0o644, Size: 1, Reason: "mtime"},
+				{Path: "old\nname", Mode: 0o644, Size: 1, Delete: true},
+				{Path: "two\nlines", Mode: 0o644, Size: 2, Reason: "new"},
+			}},
+		{"sorted, directory first", []treeEntry{file("d/a", 0o644, 1, 5), dir("d"), file("c", 0o644, 1, 5)}, nil, false,
+			[]SyncChange{
+				{Path: "c", Mode: 0o644, Size: 1, Reason: "new"},
+				{Path: "d", Mode: fs.ModeDir | 0o755, Reason: "new"},
+				{Path: "d/a", Mode: 0o644, Size: 1, Reason: "new"},
+			}},
+	}
+	for _, tt := range tests {
+		got, err := (&Client{}).syncChanges("/local", "/remote", tt.local, tt.remote, SyncOptions{Delete: tt.delete})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n  %+v\nwant\n  %+v", tt.name, got, tt.want)
+		}
+	}
+}
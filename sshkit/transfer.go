@@ -0,0 +1,303 @@
+package sshkit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// FileTransfer is one file, symlink or directory copied by UploadPaths or
+// DownloadPaths. Directories are only reported when they fail.
+type FileTransfer struct {
+	Local  string
+	Remote string
+	Size   int64
+	Mode   os.FileMode
+	// Skipped is set for uploads left out because the remote file already
+	// had the same content.
+	Skipped bool
+	Err     error
+}
+
+// UploadPaths copies local files, directories (recursively) and glob
+// patterns to dst on the remote host, keeping permission bits and
+// modification times and creating missing directories. Files are uploaded
+// with UploadFile: verified, renamed into place, and skipped when the
+// remote copy already matches. As with scp -r, a
+// single source is copied to dst itself unless dst is an existing
+// directory or ends in "/", and several sources are copied into dst.
+// Symlinks are recreated, except that a source named on the command line
+// is followed.
+//
+// Every file is passed to report, if not nil, as soon as it is done. A
+// file that fails does not stop the others; the error then counts the
+// failures, and the returned list says which.
+func (c *Client) UploadPaths(srcs []string, dst string, report func(FileTransfer)) ([]FileTransfer, error) {
+	s, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	var sources []string
+	for _, p := range srcs {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file or directory", p)
+		}
+		sources = append(sources, matches...)
+	}
+	into := len(sources) > 1 || strings.HasSuffix(dst, "/")
+	if fi, err := s.Stat(dst); err == nil && fi.IsDir() {
+		into = true
+	}
+
+	l := &transferLog{report: report}
+	for _, src := range sources {
+		target := dst
+		if into {
+			target = path.Join(dst, filepath.Base(src))
+		}
+		if err := c.uploadTree(s, src, target, l); err != nil {
+			return l.files, err
+		}
+	}
+	return l.result()
+}
+
+func (c *Client) uploadTree(s *sftp.Client, src, dst string, l *transferLog) error {
+	root := src
+	if fi, err := os.Stat(src); err == nil && fi.IsDir() {
+		// Walk through a symlink to a directory.
+		root += string(filepath.Separator)
+	}
+	var dirs []dirTimes
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		remote := dst
+		if rel, _ := filepath.Rel(root, p); rel != "." {
+			remote = path.Join(dst, filepath.ToSlash(rel))
+		} else {
+			p = src
+		}
+		if err != nil {
+			if d == nil && p == src {
+				return err
+			}
+			l.add(FileTransfer{Local: p, Remote: remote, Err: err})
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if p == src {
+			info, err = os.Stat(p)
+		}
+		if err != nil {
+			l.add(FileTransfer{Local: p, Remote: remote, Err: err})
+			return nil
+		}
+		f := FileTransfer{Local: p, Remote: remote, Mode: info.Mode()}
+		switch {
+		case info.IsDir():
+			if err := s.MkdirAll(remote); err != nil {
+				f.Err = fmt.Errorf("mkdir: %w", c.inFlight(c.lostChan(), err))
+				l.add(f)
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, dirTimes{remote, info.Mode(), info.ModTime()})
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err == nil {
+				_ = s.Remove(remote)
+				err = s.Symlink(target, remote)
+			}
+			f.Err = err
+			l.add(f)
+		case info.Mode().IsRegular():
+			f.Size = info.Size()
+			if f.Skipped, f.Err = c.uploadFile(p, remote, info.Mode()); f.Err == nil {
+				f.Err = s.Chtimes(remote, info.ModTime(), info.ModTime())
+			}
+			l.add(f)
+		default:
+			f.Err = fmt.Errorf("not a regular file (%s)", info.Mode().Type())
+			l.add(f)
+		}
+		return nil
+	})
+	// Directory times last, as filling a directory changes its mtime.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := s.Chmod(d.path, d.mode.Perm()); err == nil {
+			_ = s.Chtimes(d.path, d.mtime, d.mtime)
+		}
+	}
+	return err
+}
+
+// DownloadPaths is UploadPaths the other way: it copies remote files,
+// directories and glob patterns (matched on the remote host) to dst on
+// the local machine.
+func (c *Client) DownloadPaths(srcs []string, dst string, report func(FileTransfer)) ([]FileTransfer, error) {
+	s, err := c.SFTP()
+	if err != nil {
+		return nil, err
+	}
+	var sources []string
+	for _, p := range srcs {
+		matches, err := s.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file or directory", p)
+		}
+		sources = append(sources, matches...)
+	}
+	into := len(sources) > 1 || strings.HasSuffix(dst, string(filepath.Separator)) || strings.HasSuffix(dst, "/")
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		into = true
+	}
+
+	l := &transferLog{report: report}
+	for _, src := range sources {
+		target := dst
+		if into {
+			target = filepath.Join(dst, path.Base(src))
+		}
+		if err := c.downloadTree(s, src, target, l); err != nil {
+			return l.files, err
+		}
+	}
+	return l.result()
+}
+
+func (c *Client) downloadTree(s *sftp.Client, src, dst string, l *transferLog) error {
+	root := src
+	if fi, err := s.Stat(src); err == nil && fi.IsDir() {
+		// Walk through a symlink to a directory.
+		root += "/"
+	}
+	var dirs []dirTimes
+	w := s.Walk(root)
+	for w.Step() {
+		p := w.Path()
+		local := dst
+		if rel := relPath(root, p); rel != "" {
+			local = filepath.Join(dst, filepath.FromSlash(rel))
+		} else {
+			p = src
+		}
+		info := w.Stat()
+		if err := w.Err(); err != nil {
+			if p == src {
+				return err
+			}
+			l.add(FileTransfer{Local: local, Remote: p, Err: err})
+			if info != nil && info.IsDir() {
+				w.SkipDir()
+			}
+			continue
+		}
+		if p == src {
+			var err error
+			if info, err = s.Stat(p); err != nil {
+				return err
+			}
+		}
+		f := FileTransfer{Local: local, Remote: p, Mode: info.Mode()}
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(local, 0o755); err != nil {
+				f.Err = err
+				l.add(f)
+				w.SkipDir()
+				continue
+			}
+			dirs = append(dirs, dirTimes{local, info.Mode(), info.ModTime()})
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := s.ReadLink(p)
+			if err == nil {
+				_ = os.Remove(local)
+				err = os.Symlink(target, local)
+			}
+			f.Err = err
+			l.add(f)
+		case info.Mode().IsRegular():
+			f.Size = info.Size()
+			f.Err = os.MkdirAll(filepath.Dir(local), 0o755)
+			if f.Err == nil {
+				f.Err = c.Download(p, local)
+			}
+			if f.Err == nil {
+				if f.Err = os.Chmod(local, info.Mode().Perm()); f.Err == nil {
+					f.Err = os.Chtimes(local, info.ModTime(), info.ModTime())
+				}
+			}
+			l.add(f)
+		default:
+			f.Err = fmt.Errorf("not a regular file (%s)", info.Mode().Type())
+			l.add(f)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.mode.Perm()); err == nil {
+			_ = os.Chtimes(d.path, d.mtime, d.mtime)
+		}
+	}
+	return nil
+}
+
+// relPath returns p relative to the remote directory root it was found
+// under, "" for root itself.
+func relPath(root, p string) string {
+	root, p = path.Clean(root), path.Clean(p)
+	if p == root {
+		return ""
+	}
+	if root == "." {
+		return p
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+}
+
+// dirTimes is a copied directory whose mode and mtime are set once its
+// contents are in place.
+type dirTimes struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+// transferLog collects the files of a transfer.
+type transferLog struct {
+	report func(FileTransfer)
+	files  []FileTransfer
+	failed int
+}
+
+func (l *transferLog) add(f FileTransfer) {
+	l.files = append(l.files, f)
+	if f.Err != nil {
+		l.failed++
+	}
+	if l.report != nil {
+		l.report(f)
+	}
+}
+
+func (l *transferLog) result() ([]FileTransfer, error) {
+	if l.failed > 0 {
+		return l.files, fmt.Errorf("%d of %d files failed", l.failed, len(l.files))
+	}
+	return l.files, nil
+}
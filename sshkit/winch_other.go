@@ -0,0 +1,10 @@
+//go:build !unix
+
+package sshkit
+
+import "golang.org/x/crypto/ssh"
+
+// watchWindowSize is a no-op where there is no SIGWINCH.
+func watchWindowSize(session *ssh.Session, fd int) func() {
+	return func() {}
+}
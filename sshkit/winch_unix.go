@@ -0,0 +1,36 @@
+//go:build unix
+
+package sshkit
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchWindowSize forwards SIGWINCH as window-change requests until the
+// returned function is called.
+func watchWindowSize(session *ssh.Session, fd int) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				if w, h, err := term.GetSize(fd); err == nil {
+					session.WindowChange(h, w)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
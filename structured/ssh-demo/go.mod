@@ -6,11 +6,16 @@ toolchain go1.24.6
 
 require (
 	github.com/hashicorp/vault v1.20.2
-	github.com/pkg/sftp v1.13.9
-	golang.org/x/crypto v0.41.0
+	sshkit v0.0.0
 )
 
 require (
 	github.com/kr/fs v0.1.0 // indirect
+	github.com/pkg/sftp v1.13.9 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/term v0.34.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace sshkit => ../../sshkit
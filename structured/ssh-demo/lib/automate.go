@@ -1,24 +1,82 @@
 package lib
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
-// Automate is a simple demo automation workflow
-func Automate(client *ssh.Client) error {
-	cmds := []string{
-		"echo 'Starting automation...'",
-		"hostname",
-		"uptime",
-	}
-	for _, c := range cmds {
+// automateCmds is the demo automation workflow.
+var automateCmds = []string{
+	"echo 'Starting automation...'",
+	"hostname",
+	"uptime",
+}
+
+// AutomateSteps runs the demo automation workflow on client and returns
+// each command's result, labelled with host. It stops at the first
+// command that fails or exits non-zero, so that a rollout counts the host
+// as failed.
+func AutomateSteps(client *sshkit.Client, host string, w io.Writer) ([]CommandResult, error) {
+	var steps []CommandResult
+	for _, c := range automateCmds {
+		start := time.Now()
 		code, out, errOut, err := RunRemoteCommand(client, c)
-		fmt.Printf("cmd=%q exit=%d\nstdout=%s\nstderr=%s\n", c, code, out, errOut)
+		fmt.Fprintf(w, "cmd=%q exit=%d\nstdout=%s\nstderr=%s\n", c, code, out, errOut)
+		steps = append(steps, CommandResult{
+			Host: host, Cmd: c, Exit: code, Stdout: out, Stderr: errOut,
+			Duration: seconds(time.Since(start)), Error: errString(err),
+		})
 		if err != nil {
-			return err
+			return steps, err
+		}
+		if code != 0 {
+			return steps, fmt.Errorf("%q exited %d", c, code)
 		}
 	}
-	return nil
+	return steps, nil
+}
+
+// AutomateAll runs AutomateSteps on the inventory hosts as a rolling
+// rollout.
+// Each host's output is written to w as one block when it finishes; the
+// commands' results are returned per host alongside the rollout results.
+func AutomateAll(dialer *sshkit.Dialer, hosts []*sshkit.Host, rollout *sshkit.Rollout, w io.Writer) ([]sshkit.StepResult, [][]CommandResult, error) {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.Name
+	}
+	steps := make([][]CommandResult, len(hosts))
+	var printMu sync.Mutex
+	results, err := rollout.Run(names, func(i int) error {
+		var out bytes.Buffer
+		var err error
+		steps[i], err = automateHost(dialer, hosts[i], &out)
+		printMu.Lock()
+		defer printMu.Unlock()
+		fmt.Fprintf(w, "=== %s ===\n", hosts[i].Name)
+		w.Write(out.Bytes())
+		if err != nil {
+			fmt.Fprintf(w, "❌ %v\n", err)
+		}
+		return err
+	})
+	return results, steps, err
+}
+
+func automateHost(dialer *sshkit.Dialer, h *sshkit.Host, w io.Writer) ([]CommandResult, error) {
+	target, err := h.Target()
+	if err != nil {
+		return nil, err
+	}
+	client, err := Connect(h.Dialer(dialer), target)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return AutomateSteps(client, h.Name, w)
 }
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package lib
+
+import "os/exec"
+
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package lib
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts cmd in its own session so the broker outlives the
+// invocation that started it and never reads from its terminal.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
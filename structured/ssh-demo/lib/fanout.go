@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"sshkit"
+)
+
+// FanOutOptions control ExecAll.
+type FanOutOptions struct {
+	Concurrency int           // hosts running at once (default 10)
+	Timeout     time.Duration // per-host command timeout, 0 for none
+	KillGrace   time.Duration // SIGTERM to SIGKILL delay on timeout
+	// Interleave streams output as it arrives with "[host out] " prefixes;
+	// otherwise each host's output is printed as one block when it finishes.
+	Interleave bool
+	Out        io.Writer
+	ErrOut     io.Writer
+	Env        []string // KEY=value variables for the command
+	Dir        string   // remote working directory
+	Input      []byte   // fed to the command's stdin on every host; nil for none
+	// Template runs the command as a text/template with the host's vars
+	// instead of as is; a host without a var the command uses fails.
+	Template bool
+}
+
+// HostResult is the outcome of a command on one inventory host.
+type HostResult struct {
+	Host     string
+	Cmd      string // the command run, after any templating
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	Err      error // connection or transport failure, or a timeout
+}
+
+// ExecAll runs cmd on every host with at most opts.Concurrency at a time
+// and returns the results in host order, with an error if any host failed
+// to run it or exited non-zero. With opts.Template, cmd is a text/template
+// executed with the host's vars, e.g. "echo {{.role}}"; {{.name}} is the
+// host name and {{quote .role}} inserts a var as a single shell word.
+// A host without a var the template uses fails without being connected
+// to. A template that does not parse returns no results.
+func ExecAll(ctx context.Context, dialer *sshkit.Dialer, hosts []*sshkit.Host, cmd string, opts FanOutOptions) ([]HostResult, error) {
+	var tmpl *template.Template
+	if opts.Template {
+		var err error
+		tmpl, err = template.New("cmd").Option("missingkey=error").Funcs(template.FuncMap{"quote": sshkit.Quote}).Parse(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("command template: %w", err)
+		}
+	}
+	limit := opts.Concurrency
+	if limit <= 0 {
+		limit = 10
+	}
+
+	results := make([]HostResult, len(hosts))
+	var printMu sync.Mutex
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = execHost(ctx, dialer, h, cmd, tmpl, opts)
+			if !opts.Interleave {
+				printMu.Lock()
+				printGrouped(opts.Out, results[i])
+				printMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil || r.ExitCode != 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d hosts failed", failed, len(results))
+	}
+	return results, nil
+}
+
+func execHost(ctx context.Context, dialer *sshkit.Dialer, h *sshkit.Host, cmd string, tmpl *template.Template, opts FanOutOptions) (res HostResult) {
+	start := time.Now()
+	res = HostResult{Host: h.Name, Cmd: cmd, ExitCode: -1}
+	defer func() { res.Duration = time.Since(start) }()
+
+	if tmpl != nil {
+		vars := map[string]string{"name": h.Name}
+		for k, v := range h.Vars {
+			vars[k] = v
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, vars); err != nil {
+			res.Err = fmt.Errorf("command template: %w", err)
+			return res
+		}
+		res.Cmd = b.String()
+	}
+
+	target, err := h.Target()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	client, err := Connect(h.Dialer(dialer), target)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer client.Close()
+	client.KillGrace = opts.KillGrace
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	eo := ExecOptions{Env: opts.Env, Dir: opts.Dir}
+	if opts.Input != nil {
+		eo.Stdin = bytes.NewReader(opts.Input)
+	}
+	var r *sshkit.Result
+	if opts.Interleave {
+		stdout := sshkit.NewPrefixWriter(opts.Out, fmt.Sprintf("[%s out] ", h.Name))
+		stderr := sshkit.NewPrefixWriter(opts.ErrOut, fmt.Sprintf("[%s err] ", h.Name))
+		r, res.Err = eo.exec(ctx, client, res.Cmd, stdout, stderr, 0)
+	} else {
+		r, res.Err = eo.exec(ctx, client, res.Cmd, nil, nil, 0)
+	}
+	if r != nil {
+		res.ExitCode, res.Stdout, res.Stderr = r.ExitCode, r.Stdout, r.Stderr
+	}
+	return res
+}
+
+// CommandResult returns r in the form printed by -output json.
+func (r HostResult) CommandResult() CommandResult {
+	return CommandResult{
+		Host: r.Host, Cmd: r.Cmd, Exit: r.ExitCode, Stdout: r.Stdout, Stderr: r.Stderr,
+		Duration: seconds(r.Duration), Error: errString(r.Err),
+	}
+}
+
+func printGrouped(w io.Writer, r HostResult) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "=== %s (%s) ===\n", r.Host, status(r))
+	b.WriteString(r.Stdout)
+	if r.Stdout != "" && !strings.HasSuffix(r.Stdout, "\n") {
+		b.WriteByte('\n')
+	}
+	if r.Stderr != "" {
+		b.WriteString("--- stderr ---\n")
+		b.WriteString(r.Stderr)
+		if !strings.HasSuffix(r.Stderr, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	w.Write(b.Bytes())
+}
+
+// PrintSummary writes one line per host and the totals, and reports
+// whether every host exited 0.
+func PrintSummary(w io.Writer, results []HostResult) bool {
+	var ok, failed, errored int
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "=== summary ===")
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			errored++
+		case r.ExitCode != 0:
+			failed++
+		default:
+			ok++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Host, status(r), r.Duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d hosts: %d ok, %d failed, %d unreachable or timed out\n", len(results), ok, failed, errored)
+	return failed == 0 && errored == 0
+}
+
+func status(r HostResult) string {
+	if r.Err != nil {
+		return "error: " + r.Err.Error()
+	}
+	return fmt.Sprintf("exit=%d", r.ExitCode)
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sshkit"
+)
+
+// TestExecAllTemplate checks how the command is expanded for each host.
+// The hosts have an invalid keepalive var, so they fail before anything
+// is dialed, with the command already worked out.
+func TestExecAllTemplate(t *testing.T) {
+	t.Setenv("SSH_CONFIG", filepath.Join(t.TempDir(), "none"))
+	host := func(name string, vars map[string]string) *sshkit.Host {
+		v := map[string]string{"host": "192.0.2.1", "keepalive": "never"}
+		for k, x := range vars {
+			v[k] = x
+		}
+		return &sshkit.Host{Name: name, Vars: v}
+	}
+	tests := []struct {
+		name     string
+		cmd      string
+		template bool
+		vars     map[string]string
+		want     string // the command run, or part of the error
+		tmplErr  bool   // the host fails on the template
+	}{
+		{"literal without -template", "echo {{.role}} $HOME", false, map[string]string{"role": "web"}, "echo {{.role}} $HOME", false},
+		{"literal with a missing var", "echo {{.nothing}}", false, nil, "echo {{.nothing}}", false},
+		{"var", "echo {{.role}}", true, map[string]string{"role": "web"}, "echo web", false},
+		{"host name", "hostname -s | grep -x {{.name}}", true, nil, "hostname -s | grep -x web01", false},
+		{"quoted var", "echo {{quote .motto}}", true, map[string]string{"motto": "it's ok"}, `echo 'it'\''s ok'`, false},
+		{"missing var", "echo {{.role}}", true, nil, `"role"`, true},
+	}
+	for _, tt := range tests {
+		opts := FanOutOptions{Template: tt.template, Out: io.Discard, ErrOut: io.Discard}
+		results, err := ExecAll(context.Background(), &sshkit.Dialer{Auth: &sshkit.Auth{}}, []*sshkit.Host{host("web01", tt.vars)}, tt.cmd, opts)
+		if err == nil || len(results) != 1 {
+			t.Fatalf("%s: %v, %v; want one failed host", tt.name, results, err)
+		}
+		r := results[0]
+		if tt.tmplErr {
+			if r.Err == nil || !strings.Contains(r.Err.Error(), "command template") || !strings.Contains(r.Err.Error(), tt.want) {
+				t.Errorf("%s: error %v, want a template error about %s", tt.name, r.Err, tt.want)
+			}
+			continue
+		}
+		if r.Cmd != tt.want {
+			t.Errorf("%s: ran %q, want %q", tt.name, r.Cmd, tt.want)
+		}
+		if r.Err == nil || strings.Contains(r.Err.Error(), "command template") {
+			t.Errorf("%s: error %v, want the keepalive error", tt.name, r.Err)
+		}
+	}
+
+	if results, err := ExecAll(context.Background(), nil, []*sshkit.Host{host("web01", nil)}, "echo {{.role", FanOutOptions{Template: true}); err == nil || results != nil {
+		t.Errorf("unparsable template: %v, %v; want an error and no results", results, err)
+	}
+}
@@ -5,17 +5,15 @@ import (
 	"os"
 	"time"
 
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 // WriteRemoteLog appends a line to a remote log file (creating it if needed).
-func WriteRemoteLog(client *ssh.Client, remotePath, message string) error {
-	s, err := sftp.NewClient(client)
+func WriteRemoteLog(client *sshkit.Client, remotePath, message string) error {
+	s, err := client.SFTP()
 	if err != nil {
-		return fmt.Errorf("sftp: %w", err)
+		return err
 	}
-	defer s.Close()
 
 	f, err := s.OpenFile(remotePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND)
 	if err != nil {
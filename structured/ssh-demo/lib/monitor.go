@@ -2,13 +2,14 @@ package lib
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
 // CollectBasicStats runs common commands on remote host
-func CollectBasicStats(client *ssh.Client) (string, error) {
+func CollectBasicStats(client *sshkit.Client) (string, error) {
 	parts := []struct {
 		title string
 		cmd   string
@@ -31,3 +32,117 @@ func CollectBasicStats(client *ssh.Client) (string, error) {
 	}
 	return b.String(), nil
 }
+
+// Stats is the machine-readable form of the report from CollectBasicStats.
+// Memory is nil on hosts without /proc/meminfo.
+type Stats struct {
+	Uname  string    `json:"uname"`
+	Uptime string    `json:"uptime"`
+	Load   []float64 `json:"load"` // 1, 5 and 15 minute load averages
+	Memory *Memory   `json:"memory,omitempty"`
+	Disks  []Disk    `json:"disks"`
+}
+
+// Memory sizes are in bytes.
+type Memory struct {
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`
+	Available uint64 `json:"available"`
+}
+
+// Disk is one filesystem from df; sizes are in bytes.
+type Disk struct {
+	Filesystem string `json:"filesystem"`
+	Mount      string `json:"mount"`
+	Size       uint64 `json:"size"`
+	Used       uint64 `json:"used"`
+	Available  uint64 `json:"available"`
+}
+
+// CollectStats gathers the same information as CollectBasicStats, parsed.
+func CollectStats(client *sshkit.Client) (*Stats, error) {
+	run := func(cmd string) (string, bool, error) {
+		code, out, _, err := RunRemoteCommand(client, cmd)
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", cmd, err)
+		}
+		return out, code == 0, nil
+	}
+	s := &Stats{Load: []float64{}, Disks: []Disk{}}
+
+	out, _, err := run("uname -a")
+	if err != nil {
+		return nil, err
+	}
+	s.Uname = strings.TrimSpace(out)
+
+	if out, _, err = run("uptime"); err != nil {
+		return nil, err
+	}
+	s.Uptime = strings.TrimSpace(out)
+	if i := strings.LastIndex(s.Uptime, "load average"); i >= 0 {
+		_, avg, _ := strings.Cut(s.Uptime[i:], ":")
+		for _, f := range strings.FieldsFunc(avg, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if v, err := strconv.ParseFloat(f, 64); err == nil {
+				s.Load = append(s.Load, v)
+			}
+		}
+	}
+
+	out, ok, err := run("cat /proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		s.Memory = parseMeminfo(out)
+	}
+
+	if out, _, err = run("df -kP"); err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(out, "\n")[1:] {
+		f := strings.Fields(line)
+		if len(f) < 6 {
+			continue
+		}
+		size, _ := strconv.ParseUint(f[1], 10, 64)
+		used, _ := strconv.ParseUint(f[2], 10, 64)
+		avail, _ := strconv.ParseUint(f[3], 10, 64)
+		s.Disks = append(s.Disks, Disk{
+			Filesystem: f[0],
+			Mount:      strings.Join(f[5:], " "),
+			Size:       size << 10,
+			Used:       used << 10,
+			Available:  avail << 10,
+		})
+	}
+	return s, nil
+}
+
+// parseMeminfo reads the kB values of /proc/meminfo.
+func parseMeminfo(out string) *Memory {
+	m := &Memory{}
+	for _, line := range strings.Split(out, "\n") {
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(rest)
+		if len(f) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "MemTotal":
+			m.Total = v << 10
+		case "MemFree":
+			m.Free = v << 10
+		case "MemAvailable":
+			m.Available = v << 10
+		}
+	}
+	return m
+}
@@ -0,0 +1,257 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sshkit"
+)
+
+// MuxServeCommand is the hidden subcommand the background broker runs as.
+const MuxServeCommand = "mux-serve"
+
+// brokerStartTimeout bounds how long Connect waits for a new broker to
+// dial the host and start listening.
+const brokerStartTimeout = 60 * time.Second
+
+// ControlPersist parses SSH_CONTROL_PERSIST: a duration ("10m"), a number
+// of seconds, or "yes" to keep the broker until `close`. Unset, "no" and
+// "0" disable multiplexing.
+func ControlPersist() (enabled bool, idle time.Duration, err error) {
+	v := strings.TrimSpace(os.Getenv("SSH_CONTROL_PERSIST"))
+	switch strings.ToLower(v) {
+	case "", "no", "0":
+		return false, 0, nil
+	case "yes":
+		return true, 0, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return n > 0, time.Duration(n) * time.Second, nil
+	}
+	idle, err = time.ParseDuration(v)
+	if err != nil {
+		return false, 0, fmt.Errorf("SSH_CONTROL_PERSIST: %w", err)
+	}
+	return idle > 0, idle, nil
+}
+
+// Connect returns a client for target. With SSH_CONTROL_PERSIST set it
+// goes through the local mux broker for target, starting one in the
+// background if none is running, so repeated invocations share one
+// authenticated connection. Targets whose settings a broker cannot be
+// given (see brokerEnv) are dialed directly. A lost connection is redialed
+// directly with d.
+func Connect(d *sshkit.Dialer, target *sshkit.Target) (*sshkit.Client, error) {
+	enabled, _, err := ControlPersist()
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return d.Connect(target)
+	}
+	env, err := brokerEnv(d, target)
+	if err != nil {
+		return d.Connect(target)
+	}
+	path, err := d.MuxPath(target)
+	if err != nil {
+		return nil, err
+	}
+	if c, err := sshkit.DialMux(path); err == nil {
+		return sshkit.NewClient(c, target, d), nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("start mux broker: %w", err)
+	}
+	logPath := path + ".log"
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("start mux broker: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, MuxServeCommand)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start mux broker: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(brokerStartTimeout)
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if c, err := sshkit.DialMux(path); err == nil {
+				return sshkit.NewClient(c, target, d), nil
+			}
+		case <-exited:
+			// A broker started concurrently by another invocation wins
+			// the socket; this one exits cleanly and we use the other.
+			if c, err := sshkit.DialMux(path); err == nil {
+				return sshkit.NewClient(c, target, d), nil
+			}
+			msg, _ := os.ReadFile(logPath)
+			return nil, fmt.Errorf("mux broker exited: %s", strings.TrimSpace(string(msg)))
+		case <-deadline:
+			return nil, fmt.Errorf("mux broker did not start within %s (see %s)", brokerStartTimeout, logPath)
+		}
+	}
+}
+
+// brokerEnv returns the environment under which the broker, which reads
+// its target from SSH_HOST and its Dialer from DialerFromEnv, dials target
+// just as d would. It fails for what cannot be passed that way: a connect
+// timeout, per-hop credentials, jump hosts from the inventory, ssh config
+// settings the host does not resolve to again, or a password that would
+// have to be typed, as the broker has no terminal.
+func brokerEnv(d *sshkit.Dialer, target *sshkit.Target) ([]string, error) {
+	a := d.Auth
+	switch {
+	case d.Timeout != 0:
+		return nil, errors.New("connect timeout")
+	case len(d.HopAuth) > 0 || d.JumpAuth != nil:
+		return nil, errors.New("per-hop credentials")
+	case a.Password == "" && !slices.Contains(a.Order, sshkit.AuthAgent) && !slices.Contains(a.Order, sshkit.AuthKey):
+		return nil, errors.New("password prompt")
+	}
+
+	// An inventory host's name may not resolve; its address does.
+	var spec string
+	var r *sshkit.Target
+	for _, host := range []string{target.Alias, target.HostName} {
+		spec = target.User + "@" + net.JoinHostPort(host, target.Port)
+		if t, err := sshkit.Resolve(spec); err == nil && t.String() == target.String() {
+			r = t
+			break
+		}
+	}
+	switch {
+	case r == nil:
+		return nil, fmt.Errorf("%s does not resolve to %s", target.Alias, target)
+	case r.ConnectTimeout != target.ConnectTimeout || r.ServerAliveCountMax != target.ServerAliveCountMax:
+		return nil, errors.New("ssh config settings")
+	}
+	keepalive, reconnect := d.KeepAlive, d.Reconnect
+	if r.ServerAliveInterval != target.ServerAliveInterval {
+		if r.ServerAliveInterval != 0 {
+			return nil, errors.New("keepalive")
+		}
+		keepalive = target.ServerAliveInterval
+	}
+	if r.Reconnect != target.Reconnect {
+		if r.Reconnect != 0 {
+			return nil, errors.New("reconnect")
+		}
+		reconnect = target.Reconnect
+	}
+
+	jump := target.ProxyJump
+	if d.Jump != "" {
+		jump = d.Jump
+	}
+	if d.ResolveJump != nil {
+		for _, spec := range strings.Split(jump, ",") {
+			if spec = strings.TrimSpace(spec); spec == "" || strings.EqualFold(spec, "none") {
+				continue
+			}
+			h, err := d.ResolveJump(spec)
+			if err != nil {
+				return nil, err
+			}
+			if r, err := sshkit.Resolve(spec); err != nil || r.String() != h.String() || r.ProxyJump != h.ProxyJump || !slices.Equal(r.IdentityFiles, h.IdentityFiles) {
+				return nil, errors.New("inventory jump hosts")
+			}
+		}
+	}
+	if jump == "" {
+		// Not the ProxyJump the broker's ssh config may have.
+		jump = "none"
+	}
+	ka, rc := "", ""
+	switch {
+	case keepalive < 0:
+		ka = "off"
+	case keepalive > 0:
+		ka = keepalive.String()
+	}
+	switch {
+	case reconnect < 0:
+		rc = "0"
+	case reconnect > 0:
+		rc = strconv.Itoa(reconnect)
+	}
+	keys := append(append([]string(nil), a.KeyFiles...), target.IdentityFiles...)
+	return []string{
+		"SSH_HOST=" + spec,
+		"SSH_JUMP=" + jump,
+		"SSH_KEY=" + strings.Join(keys, string(os.PathListSeparator)),
+		"SSH_AUTH_ORDER=" + strings.Join(a.Order, ","),
+		"SSH_PASS=" + a.Password,
+		"SSH_KEY_PASSPHRASE=" + a.Passphrase,
+		"SSH_AUTH_SOCK=" + a.AgentSock,
+		"SSH_KEEPALIVE=" + ka,
+		"SSH_RECONNECT=" + rc,
+	}, nil
+}
+
+// ServeBroker dials target directly and serves it on its mux socket until
+// the idle timeout from SSH_CONTROL_PERSIST or `close`.
+func ServeBroker(d *sshkit.Dialer, target *sshkit.Target) error {
+	_, idle, err := ControlPersist()
+	if err != nil {
+		return err
+	}
+	path, err := d.MuxPath(target)
+	if err != nil {
+		return err
+	}
+	client, err := d.Connect(target)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	conn, err := client.Conn()
+	if err != nil {
+		return err
+	}
+	err = sshkit.ServeMux(conn, path, idle)
+	if errors.Is(err, sshkit.ErrMuxRunning) {
+		return nil
+	}
+	return err
+}
+
+// CloseBroker stops the mux broker for target. It reports false when no
+// broker was running, removing a stale socket left by one that died.
+func CloseBroker(d *sshkit.Dialer, target *sshkit.Target) (bool, error) {
+	path, err := d.MuxPath(target)
+	if err != nil {
+		return false, err
+	}
+	err = sshkit.CloseMux(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		os.Remove(path)
+		return false, nil
+	}
+	return err == nil, err
+}
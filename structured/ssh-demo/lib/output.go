@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Output prints command results as human-readable text or, for scripts,
+// as one JSON document on stdout.
+type Output struct {
+	JSON bool
+}
+
+// NewOutput returns the Output for format "text" or "json".
+func NewOutput(format string) (*Output, error) {
+	switch format {
+	case "", "text":
+		return &Output{}, nil
+	case "json":
+		return &Output{JSON: true}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (want text or json)", format)
+}
+
+// Print writes v as indented JSON, or calls text to print it as text.
+func (o *Output) Print(v any, text func()) {
+	if !o.JSON {
+		text()
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("encode output: %v", err)
+	}
+}
+
+// Fatalf reports an error and exits with status 1: {"error": "..."} on
+// stdout for JSON, a log line on stderr for text.
+func (o *Output) Fatalf(format string, args ...any) {
+	o.Exit(1, fmt.Errorf(format, args...))
+}
+
+// Exit reports err like Fatalf and exits with code.
+func (o *Output) Exit(code int, err error) {
+	if o.JSON {
+		o.Print(map[string]any{"error": err.Error(), "exit": code}, nil)
+	} else {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
+// CommandResult is one remote command's outcome as reported by exec and
+// automate. Duration is in seconds; Error is set when the command did not
+// run to completion (connection failure, timeout).
+type CommandResult struct {
+	Host     string  `json:"host"`
+	Cmd      string  `json:"cmd"`
+	Exit     int     `json:"exit"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func seconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -1,33 +1,74 @@
 package lib
 
 import (
-	"bytes"
+	"context"
 	"fmt"
+	"io"
 
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
-// RunRemoteCommand executes a command over SSH and returns exit code, stdout, stderr
-func RunRemoteCommand(client *ssh.Client, cmd string) (int, string, string, error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return -1, "", "", fmt.Errorf("new session: %w", err)
+// ExecOptions set up the remote process beyond its command line.
+type ExecOptions struct {
+	Env   []string  // KEY=value variables
+	Dir   string    // working directory
+	Stdin io.Reader // copied to the command's stdin; nil for none
+}
+
+// command returns the sshkit command running line with o applied, or nil
+// when o is empty and line can be run as it is. With a directory or
+// variables the line is run by sh -c.
+func (o ExecOptions) command(line string) *sshkit.Command {
+	if len(o.Env) == 0 && o.Dir == "" && o.Stdin == nil {
+		return nil
 	}
-	defer session.Close()
-
-	var stdout, stderr bytes.Buffer
-	session.Stdout = &stdout
-	session.Stderr = &stderr
-
-	err = session.Run(cmd)
-	exitCode := 0
-	if err != nil {
-		if e, ok := err.(*ssh.ExitError); ok {
-			exitCode = e.ExitStatus()
-		} else {
-			return -1, "", "", fmt.Errorf("run: %w", err)
-		}
+	cmd := sshkit.Script(line).In(o.Dir)
+	cmd.Env = o.Env
+	return cmd
+}
+
+// exec runs line with o applied, streaming its output when stdout is set.
+func (o ExecOptions) exec(ctx context.Context, client *sshkit.Client, line string, stdout, stderr io.Writer, tail int) (*sshkit.Result, error) {
+	cmd := o.command(line)
+	switch {
+	case stdout == nil && cmd == nil:
+		return client.ExecContext(ctx, line)
+	case stdout == nil:
+		return client.ExecCommand(ctx, cmd, o.Stdin)
+	case cmd == nil:
+		return client.StreamContext(ctx, line, stdout, stderr, tail)
 	}
+	return client.StreamCommand(ctx, cmd, o.Stdin, stdout, stderr, tail)
+}
 
-	return exitCode, stdout.String(), stderr.String(), nil
+// RunRemoteCommand executes a command over SSH and returns exit code, stdout, stderr
+func RunRemoteCommand(client *sshkit.Client, cmd string) (int, string, string, error) {
+	return RunRemoteCommandContext(context.Background(), client, cmd, ExecOptions{})
+}
+
+// RunRemoteCommandContext is RunRemoteCommand with cancellation and
+// ExecOptions. If ctx ends first the remote process is terminated and err
+// wraps sshkit.ErrTimeout (deadline) or the context error; the output
+// captured up to that point is still returned.
+func RunRemoteCommandContext(ctx context.Context, client *sshkit.Client, cmd string, opts ExecOptions) (int, string, string, error) {
+	res, err := opts.exec(ctx, client, cmd, nil, nil, 0)
+	if res == nil {
+		return -1, "", "", err
+	}
+	return res.ExitCode, res.Stdout, res.Stderr, err
+}
+
+// StreamRemoteCommand runs cmd with its output forwarded live to stdout
+// and stderr, each line prefixed with the host and stream
+// ("[web01 out] "), and returns the exit code with the last tail bytes of
+// stdout and stderr.
+func StreamRemoteCommand(ctx context.Context, client *sshkit.Client, cmd string, opts ExecOptions, tail int, stdout, stderr io.Writer) (int, string, string, error) {
+	host := client.Target.Alias
+	outW := sshkit.NewPrefixWriter(stdout, fmt.Sprintf("[%s out] ", host))
+	errW := sshkit.NewPrefixWriter(stderr, fmt.Sprintf("[%s err] ", host))
+	res, err := opts.exec(ctx, client, cmd, outW, errW, tail)
+	if res == nil {
+		return -1, "", "", err
+	}
+	return res.ExitCode, res.Stdout, res.Stderr, err
 }
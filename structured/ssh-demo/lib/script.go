@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sshkit"
+)
+
+// scriptInterpreters maps the interpreters a script can be fed to on
+// stdin to the arguments that make them read it from there. Whatever
+// follows is passed to the script as its arguments.
+var scriptInterpreters = map[string][]string{
+	"sh":      {"-s", "--"},
+	"bash":    {"-s", "--"},
+	"dash":    {"-s", "--"},
+	"ksh":     {"-s", "--"},
+	"zsh":     {"-s", "--"},
+	"python3": {"-"},
+	"python":  {"-"},
+	"perl":    {"-"},
+	"ruby":    {"-"},
+}
+
+// DetectInterpreter picks the interpreter for a local script: from its #!
+// line (#!/bin/bash, #!/usr/bin/env python3), else from the file
+// extension, else sh.
+func DetectInterpreter(path string, script []byte) string {
+	if line, _, _ := bytes.Cut(script, []byte("\n")); bytes.HasPrefix(line, []byte("#!")) {
+		fields := strings.Fields(string(line[2:]))
+		if len(fields) > 0 && filepath.Base(fields[0]) == "env" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			if name := filepath.Base(fields[0]); scriptInterpreters[name] != nil {
+				return name
+			}
+		}
+	}
+	switch filepath.Ext(path) {
+	case ".bash":
+		return "bash"
+	case ".py":
+		return "python3"
+	case ".pl":
+		return "perl"
+	case ".rb":
+		return "ruby"
+	}
+	return "sh"
+}
+
+// ScriptCommand returns the remote command line that runs a script read
+// from stdin with interpreter, passing it args. The script takes the place
+// of stdin, so commands in a shell script that read stdin consume the rest
+// of the script.
+func ScriptCommand(interpreter string, args []string) (string, error) {
+	flags, ok := scriptInterpreters[interpreter]
+	if !ok {
+		names := make([]string, 0, len(scriptInterpreters))
+		for name := range scriptInterpreters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown interpreter %q (want one of %s)", interpreter, strings.Join(names, ", "))
+	}
+	return sshkit.NewCommand(interpreter, append(append([]string{}, flags...), args...)...).String(), nil
+}
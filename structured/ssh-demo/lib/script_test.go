@@ -0,0 +1,56 @@
+package lib
+
+import "testing"
+
+func TestDetectInterpreter(t *testing.T) {
+	tests := []struct {
+		path, script, want string
+	}{
+		{"deploy.sh", "#!/bin/bash\necho hi\n", "bash"},
+		{"deploy", "#!/bin/sh\n", "sh"},
+		{"deploy", "#! /bin/dash -e\n", "dash"},
+		{"tool", "#!/usr/bin/env python3\nprint(1)\n", "python3"},
+		{"tool", "#!/usr/bin/env perl -w\n", "perl"},
+		{"tool.py", "#!/usr/local/bin/ruby\r\n", "ruby"},
+		{"tool.py", "#!/usr/bin/env -S python3 -u\n", "python3"},
+		{"tool.rb", "#!/usr/bin/awk -f\n", "ruby"},
+		{"tool", "#!/usr/bin/awk -f\n", "sh"},
+		{"tool.py", "#!\n", "python3"},
+		{"tool.py", "print(1)\n", "python3"},
+		{"tool.pl", "", "perl"},
+		{"tool.rb", "puts 1", "ruby"},
+		{"setup.bash", "echo hi", "bash"},
+		{"setup.zsh", "echo hi", "sh"},
+		{"notes.txt", "# not a shebang\n#!/bin/bash\n", "sh"},
+		{"noext", "", "sh"},
+	}
+	for _, tt := range tests {
+		if got := DetectInterpreter(tt.path, []byte(tt.script)); got != tt.want {
+			t.Errorf("DetectInterpreter(%q, %q) = %q, want %q", tt.path, tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	tests := []struct {
+		interpreter string
+		args        []string
+		want        string
+	}{
+		{"sh", nil, "sh -s --"},
+		{"bash", []string{"prod", "web 01"}, "bash -s -- prod 'web 01'"},
+		{"sh", []string{"-x", ""}, "sh -s -- -x ''"},
+		{"sh", []string{"$HOME", "it's"}, `sh -s -- '$HOME' 'it'\''s'`},
+		{"python3", []string{"--verbose"}, "python3 - --verbose"},
+		{"perl", []string{"a;b"}, "perl - 'a;b'"},
+	}
+	for _, tt := range tests {
+		got, err := ScriptCommand(tt.interpreter, tt.args)
+		if err != nil || got != tt.want {
+			t.Errorf("ScriptCommand(%q, %q) = %q, %v; want %q", tt.interpreter, tt.args, got, err, tt.want)
+		}
+	}
+	if _, err := ScriptCommand("awk", nil); err == nil {
+		t.Error("ScriptCommand(awk): no error")
+	}
+}
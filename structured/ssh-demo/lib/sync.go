@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+
+	"sshkit"
+)
+
+// SyncResult is one path sent or deleted by sync.
+type SyncResult struct {
+	Path   string `json:"path"`
+	Action string `json:"action"` // send or delete
+	Reason string `json:"reason,omitempty"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+}
+
+// SyncResults converts the changes of an sshkit sync for output.
+func SyncResults(changes []sshkit.SyncChange) []SyncResult {
+	results := make([]SyncResult, len(changes))
+	for i, ch := range changes {
+		action := "send"
+		if ch.Delete {
+			action = "delete"
+		}
+		results[i] = SyncResult{
+			Path: ch.Path, Action: action, Reason: ch.Reason, Size: ch.Size,
+			Mode: fmt.Sprintf("%04o", ch.Mode.Perm()),
+		}
+	}
+	return results
+}
+
+// PrintSync writes a line for one change: + for a new path, ~ for a
+// changed one and - for a deleted one, directories ending in /.
+func PrintSync(w io.Writer, ch sshkit.SyncChange) {
+	name := ch.Path
+	if ch.Mode.IsDir() {
+		name += "/"
+	}
+	switch {
+	case ch.Delete:
+		fmt.Fprintf(w, "-  %s\n", name)
+	case ch.Mode.IsRegular():
+		fmt.Fprintf(w, "%s  %s (%s, %d bytes)\n", syncMark(ch), name, ch.Reason, ch.Size)
+	default:
+		fmt.Fprintf(w, "%s  %s (%s)\n", syncMark(ch), name, ch.Reason)
+	}
+}
+
+func syncMark(ch sshkit.SyncChange) string {
+	if ch.Reason == "new" {
+		return "+"
+	}
+	return "~"
+}
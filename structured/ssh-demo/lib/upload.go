@@ -2,74 +2,52 @@ package lib
 
 import (
 	"fmt"
-	"os"
-	"path"
-	"strings"
+	"io"
 
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
+	"sshkit"
 )
 
-// UploadFile sends a local file to a remote absolute path using SFTP.
-// It creates the parent directory if needed and sets 0644 perms by default.
-func UploadFile(client *ssh.Client, localPath, remotePath string) error {
-	s, err := sftp.NewClient(client)
-	if err != nil {
-		return fmt.Errorf("sftp: %w", err)
-	}
-	defer s.Close()
-
-	parent := path.Dir(remotePath)
-	_ = s.MkdirAll(parent)
-
-	src, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("open local: %w", err)
-	}
-	defer src.Close()
-
-	dst, err := s.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("create remote: %w", err)
-	}
-	defer dst.Close()
-
-	if _, err := dst.ReadFrom(src); err != nil {
-		return fmt.Errorf("copy: %w", err)
-	}
-
-	_ = s.Chmod(remotePath, 0644)
-
-	if !strings.HasPrefix(remotePath, "/") {
-		remotePath = "/" + remotePath
-	}
+// DownloadFile downloads a remote file via the shared SFTP session to a local path
+func DownloadFile(client *sshkit.Client, remotePath, localPath string) error {
+	return client.Download(remotePath, localPath)
+}
 
-	return nil
+// TransferResult is one file copied by upload or download.
+type TransferResult struct {
+	Local   string `json:"local"`
+	Remote  string `json:"remote"`
+	Size    int64  `json:"size"`
+	Mode    string `json:"mode"`
+	Skipped bool   `json:"skipped,omitempty"` // already up to date
+	Error   string `json:"error,omitempty"`
 }
 
-// DownloadFile downloads a remote file via SFTP to a local path
-func DownloadFile(client *ssh.Client, remotePath, localPath string) error {
-	s, err := sftp.NewClient(client)
-	if err != nil {
-		return fmt.Errorf("sftp: %w", err)
+// TransferResults converts the files of an sshkit transfer for output.
+func TransferResults(files []sshkit.FileTransfer) []TransferResult {
+	results := make([]TransferResult, len(files))
+	for i, f := range files {
+		results[i] = TransferResult{
+			Local: f.Local, Remote: f.Remote, Size: f.Size,
+			Mode: fmt.Sprintf("%04o", f.Mode.Perm()), Skipped: f.Skipped, Error: errString(f.Err),
+		}
 	}
-	defer s.Close()
+	return results
+}
 
-	src, err := s.Open(remotePath)
-	if err != nil {
-		return fmt.Errorf("open remote: %w", err)
+// PrintTransfer writes a line for one file of a transfer, arrow pointing
+// the way it went.
+func PrintTransfer(w io.Writer, f sshkit.FileTransfer, upload bool) {
+	from, to := f.Remote, f.Local
+	if upload {
+		from, to = f.Local, f.Remote
 	}
-	defer src.Close()
-
-	dst, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("create local: %w", err)
+	if f.Err != nil {
+		fmt.Fprintf(w, "❌ %s -> %s: %v\n", from, to, f.Err)
+		return
 	}
-	defer dst.Close()
-
-	if _, err := dst.ReadFrom(src); err != nil {
-		return fmt.Errorf("copy: %w", err)
+	if f.Skipped {
+		fmt.Fprintf(w, "=  %s -> %s unchanged\n", from, to)
+		return
 	}
-	return nil
+	fmt.Fprintf(w, "✅ %s -> %s (%d bytes, %04o)\n", from, to, f.Size, f.Mode.Perm())
 }
-
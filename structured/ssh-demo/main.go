@@ -1,79 +1,447 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-	"strconv"  
-
-	"golang.org/x/crypto/ssh"
+	"time"
 
 	"sshdemo/lib"
+	"sshkit"
 )
 
-func main() {
-	sshHost := os.Getenv("SSH_HOST")
-	sshPass := os.Getenv("SSH_PASS")
+// output prints results and errors in the format chosen by -output.
+var output = &lib.Output{}
 
-	if sshHost == "" || sshPass == "" {
-		log.Fatal("SSH_HOST and SSH_PASS must be set")
+func main() {
+	format := flag.String("output", envOr("SSH_DEMO_OUTPUT", "text"), "output format: text or json")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	var err error
+	if output, err = lib.NewOutput(*format); err != nil {
+		log.Fatal(err)
+	}
+	if len(args) < 1 {
+		usage()
+		return
 	}
 
-	user, host := parseUserHost(sshHost)
+	dialer := sshkit.DialerFromEnv()
 
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(sshPass)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	// Broker management runs before connecting: the broker dials the host
+	// itself, and close must not start one.
+	switch args[0] {
+	case lib.MuxServeCommand:
+		if err := lib.ServeBroker(dialer, sshHostTarget()); err != nil {
+			log.Fatalf("mux broker: %v", err)
+		}
+		return
+	case "close":
+		target := sshHostTarget()
+		running, err := lib.CloseBroker(dialer, target)
+		if err != nil {
+			output.Fatalf("close failed: %v", err)
+		}
+		output.Print(map[string]any{"host": target.String(), "closed": running}, func() {
+			if running {
+				fmt.Printf("✅ closed connection to %s\n", target)
+			} else {
+				fmt.Printf("no shared connection to %s\n", target)
+			}
+		})
+		return
 	}
 
-	client, err := ssh.Dial("tcp", host+":22", config)
-	if err != nil {
-		log.Fatalf("Failed to connect: %v", err)
+	// The SSH_HOST connection is made on first use, so commands run against
+	// inventory hosts do not need SSH_HOST.
+	var shared *sshkit.Client
+	connect := func() *sshkit.Client {
+		if shared == nil {
+			c, err := lib.Connect(dialer, sshHostTarget())
+			if err != nil {
+				output.Fatalf("Failed to connect: %v", err)
+			}
+			shared = c
+		}
+		return shared
 	}
-	defer client.Close()
+	defer func() {
+		if shared != nil {
+			shared.Close()
+		}
+	}()
 
-	if len(os.Args) < 2 {
-		usage()
-		return
-	}
+	switch args[0] {
+	case "upload", "download":
+		upload := args[0] == "upload"
+		fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+		var local, remote listFlag
+		if upload {
+			fs.Var(&local, "local", "local file, directory or glob to upload (repeatable)")
+			fs.Var(&remote, "remote", "remote destination path (a directory for several sources)")
+		} else {
+			fs.Var(&remote, "remote", "remote file, directory or glob to download (repeatable)")
+			fs.Var(&local, "local", "local destination path (default: current directory)")
+		}
+		chunking := chunkFlags(fs)
+		_ = fs.Parse(args[1:])
+		srcs, dst := local, remote
+		if !upload {
+			srcs, dst = remote, local
+			if len(dst) == 0 {
+				dst = listFlag{"."}
+			}
+		}
+		if len(srcs) == 0 || len(dst) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: task upload LOCAL=<file|dir|glob> REMOTE=<path>")
+			fmt.Fprintln(os.Stderr, "       task download REMOTE=<file|dir|glob> [LOCAL=<path>]")
+			os.Exit(2)
+		}
+		client := connect()
+		chunking(client)
+		report := func(f sshkit.FileTransfer) { lib.PrintTransfer(os.Stdout, f, upload) }
+		if output.JSON {
+			report = nil
+		} else {
+			client.Progress = os.Stderr
+		}
+		var files []sshkit.FileTransfer
+		var err error
+		if upload {
+			files, err = client.UploadPaths(srcs, dst[0], report)
+		} else {
+			files, err = client.DownloadPaths(srcs, dst[0], report)
+		}
+		var total int64
+		unchanged := 0
+		for _, f := range files {
+			if f.Skipped {
+				unchanged++
+			} else {
+				total += f.Size
+			}
+		}
+		result := map[string]any{"files": lib.TransferResults(files), "bytes": total, "unchanged": unchanged}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		output.Print(result, func() {
+			if err == nil {
+				fmt.Printf("%s complete: %d files (%d unchanged), %d bytes copied\n", args[0], len(files), unchanged, total)
+			}
+		})
+		if err != nil {
+			if !output.JSON {
+				log.Printf("%s failed: %v", args[0], err)
+			}
+			client.Close()
+			os.Exit(1)
+		}
 
-	switch os.Args[1] {
-	case "upload":
-		uploadCmd := flag.NewFlagSet("upload", flag.ExitOnError)
-		localPath := uploadCmd.String("local", "", "Local file path")
-		remotePath := uploadCmd.String("remote", "", "Remote file path")
-		
-		uploadCmd.Parse(os.Args[2:])
-		
-		fmt.Println("DEBUG: LOCAL =", *localPath, "REMOTE =", *remotePath)
-		if *localPath == "" || *remotePath == "" {
-			fmt.Println("Usage: task upload LOCAL=<file> REMOTE=<path>")
+	case "sync":
+		fs := flag.NewFlagSet("sync", flag.ExitOnError)
+		local := fs.String("local", "", "local directory to copy from")
+		remote := fs.String("remote", "", "remote directory to make match it")
+		checksum := fs.Bool("checksum", false, "compare files of the same size by SHA-256 instead of mtime")
+		del := fs.Bool("delete", false, "remove remote files and directories missing locally")
+		dryRun := fs.Bool("dry-run", false, "list what would change without changing it")
+		_ = fs.Parse(args[1:])
+		if *local == "" || *remote == "" {
+			fmt.Fprintln(os.Stderr, "Usage: task sync LOCAL=<dir> REMOTE=<dir> [CHECKSUM=true] [DELETE=true] [DRY_RUN=true]")
 			os.Exit(2)
 		}
-		// Assuming lib and client are defined elsewhere in your code
-		// if err := lib.UploadFile(client, *localPath, *remotePath); err != nil {
-		//     log.Fatalf("upload failed: %v", err)
-		// }
-		fmt.Println("✅ upload complete")
+		client := connect()
+		if !output.JSON {
+			client.Progress = os.Stderr
+		}
+		changes, err := client.Sync(*local, *remote, sshkit.SyncOptions{Checksum: *checksum, Delete: *del, DryRun: *dryRun})
+		var total int64
+		sent, deleted := 0, 0
+		for _, ch := range changes {
+			if ch.Delete {
+				deleted++
+			} else {
+				sent++
+				total += ch.Size
+			}
+		}
+		result := map[string]any{"changes": lib.SyncResults(changes), "bytes": total, "dry_run": *dryRun}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		output.Print(result, func() {
+			for _, ch := range changes {
+				lib.PrintSync(os.Stdout, ch)
+			}
+			if err == nil {
+				done := "sync complete"
+				if *dryRun {
+					done = "dry run, nothing changed"
+				}
+				fmt.Printf("%s: %d to send (%d bytes), %d to delete\n", done, sent, total, deleted)
+			}
+		})
+		if err != nil {
+			if !output.JSON {
+				log.Printf("sync failed: %v", err)
+			}
+			client.Close()
+			os.Exit(1)
+		}
 
+	case "bench":
+		fs := flag.NewFlagSet("bench", flag.ExitOnError)
+		size := fs.String("size", "64MiB", "bytes to send each way")
+		dir := fs.String("dir", "/tmp", "remote directory for the test file")
+		chunking := chunkFlags(fs)
+		_ = fs.Parse(args[1:])
+		n, err := sshkit.ParseSize(*size)
+		if err != nil || n == 0 {
+			output.Fatalf("-size %q: want a byte count such as 64MiB", *size)
+		}
+		client := connect()
+		chunking(client)
+		results, err := lib.Bench(client, n, client.ChunkSize, client.Channels, *dir)
+		if err != nil {
+			client.Close()
+			output.Fatalf("bench: %v", err)
+		}
+		output.Print(results, func() { lib.PrintBench(os.Stdout, results) })
 
-	case "exec":
-		fs := flag.NewFlagSet("exec", flag.ExitOnError)
-		cmd := fs.String("cmd", "", "command to run on remote")
-		_ = fs.Parse(os.Args[2:])
+	case "exec", "script":
+		// script is exec with the command line made from a local script
+		// file, which is fed to the interpreter on stdin.
+		fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+		cmd, file, interpreter, input := new(string), new(string), new(string), new(string)
+		if args[0] == "exec" {
+			cmd = fs.String("cmd", "", "command to run on remote")
+		} else {
+			file = fs.String("file", "", "local script to run; arguments for it follow the flags")
+			interpreter = fs.String("interpreter", "auto", "remote interpreter: sh, bash, python3, perl, ... (auto: from the #! line or extension)")
+		}
+		timeout := fs.Duration("timeout", 0, "stop the command after this long (SIGTERM, then SIGKILL), e.g. 30s; per host with -hosts")
+		grace := fs.Duration("grace", sshkit.DefaultKillGrace, "time between SIGTERM and SIGKILL on timeout")
+		stream := fs.Bool("stream", false, "print output live, prefixed with host and stream, instead of at the end")
+		tail := fs.Int("tail", sshkit.DefaultTail, "with -stream, bytes of each stream kept for the result")
+		inventory := fs.String("inventory", os.Getenv("SSH_INVENTORY"), "inventory file (default $SSH_INVENTORY)")
+		hosts := fs.String("hosts", "", "run on the inventory hosts matching this pattern (groups, tags, hosts; &and, !not) instead of SSH_HOST")
+		forks := fs.Int("forks", 10, "with -hosts, how many hosts run at once")
+		interleave := fs.Bool("interleave", false, "with -hosts, stream output prefixed by host instead of grouping it per host (also -stream)")
+		tmpl := fs.Bool("template", false, "with -hosts, expand {{.var}} in the command from each host's inventory vars ({{.name}}, {{quote .var}}); a host without the var fails")
+		var env listFlag
+		fs.Var(&env, "env", "set a remote environment variable, KEY=value (repeatable)")
+		dir := fs.String("dir", "", "remote working directory")
+		if args[0] == "exec" {
+			input = fs.String("input", "none", "file fed to the command's stdin, - for local stdin, none for nothing")
+		}
+		_ = fs.Parse(args[1:])
+		label := *cmd
+		var script []byte
+		if args[0] == "script" {
+			if *file == "" {
+				fs.Usage()
+				os.Exit(2)
+			}
+			var err error
+			if script, err = os.ReadFile(*file); err != nil {
+				output.Fatalf("%v", err)
+			}
+			if *interpreter == "auto" {
+				*interpreter = lib.DetectInterpreter(*file, script)
+			}
+			if *cmd, err = lib.ScriptCommand(*interpreter, fs.Args()); err != nil {
+				output.Fatalf("%v", err)
+			}
+			label = *cmd + " < " + sshkit.Quote(*file)
+		}
 		if *cmd == "" {
 			fs.Usage()
 			os.Exit(2)
 		}
-		code, out, errOut, err := lib.RunRemoteCommand(client, *cmd)
-		fmt.Printf("exit=%d\n--- stdout ---\n%s\n--- stderr ---\n%s\n", code, out, errOut)
+		checkEnv(env)
+		stdin, err := execInput(*input)
+		if err != nil {
+			output.Fatalf("%v", err)
+		}
+		if script != nil {
+			stdin = bytes.NewReader(script)
+		}
+		if *hosts != "" {
+			selected := selectHosts(*inventory, *hosts)
+			opts := lib.FanOutOptions{
+				Concurrency: *forks,
+				Timeout:     *timeout,
+				KillGrace:   *grace,
+				Interleave:  *interleave || *stream,
+				Out:         os.Stdout,
+				ErrOut:      os.Stderr,
+				Env:         env,
+				Dir:         *dir,
+				Template:    *tmpl,
+			}
+			if stdin != nil {
+				// Every host gets the whole input.
+				if opts.Input, err = io.ReadAll(stdin); err != nil {
+					output.Fatalf("read input: %v", err)
+				}
+			}
+			if output.JSON {
+				// Live output goes to stderr; stdout holds only the results.
+				opts.Out = io.Discard
+				if opts.Interleave {
+					opts.Out = os.Stderr
+				}
+			}
+			results, err := lib.ExecAll(context.Background(), dialer, selected, *cmd, opts)
+			if results == nil && err != nil {
+				output.Fatalf("%v", err)
+			}
+			jsonResults := make([]lib.CommandResult, len(results))
+			for i, r := range results {
+				jsonResults[i] = r.CommandResult()
+			}
+			output.Print(jsonResults, func() { lib.PrintSummary(os.Stdout, results) })
+			if err != nil {
+				os.Exit(1)
+			}
+			break
+		}
+		client := connect()
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		client.KillGrace = *grace
+		start := time.Now()
+		res := lib.CommandResult{Host: os.Getenv("SSH_HOST"), Cmd: label}
+		opts := lib.ExecOptions{Env: env, Dir: *dir, Stdin: stdin}
+		if *stream {
+			if output.JSON {
+				// Live output goes to stderr; stdout holds only the result.
+				res.Exit, res.Stdout, res.Stderr, err = lib.StreamRemoteCommand(ctx, client, *cmd, opts, *tail, os.Stderr, os.Stderr)
+			} else {
+				res.Exit, res.Stdout, res.Stderr, err = lib.StreamRemoteCommand(ctx, client, *cmd, opts, *tail, os.Stdout, os.Stderr)
+				fmt.Printf("exit=%d\n", res.Exit)
+			}
+		} else {
+			res.Exit, res.Stdout, res.Stderr, err = lib.RunRemoteCommandContext(ctx, client, *cmd, opts)
+		}
+		res.Duration = time.Since(start).Round(time.Millisecond).Seconds()
 		if err != nil {
+			res.Error = err.Error()
+		}
+		if output.JSON {
+			output.Print(res, nil)
+		} else if !*stream {
+			fmt.Printf("exit=%d\n--- stdout ---\n%s\n--- stderr ---\n%s\n", res.Exit, res.Stdout, res.Stderr)
+		}
+		if errors.Is(err, sshkit.ErrTimeout) {
+			// Same status as timeout(1).
+			if !output.JSON {
+				fmt.Fprintf(os.Stderr, "remote command timed out after %s\n", *timeout)
+			}
+			client.Close()
+			os.Exit(124)
+		}
+		if err != nil {
+			if output.JSON {
+				client.Close()
+				os.Exit(1)
+			}
 			log.Fatalf("remote exec error: %v", err)
 		}
 
+	case "jobs":
+		fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+		cmd := fs.String("cmd", "", "with start: command to run detached")
+		var env listFlag
+		fs.Var(&env, "env", "with start: set a remote environment variable, KEY=value (repeatable)")
+		dir := fs.String("dir", "", "with start: remote working directory")
+		follow := fs.Bool("follow", false, "with logs: keep printing until the job ends, then exit with its status")
+		grace := fs.Duration("grace", sshkit.DefaultKillGrace, "with kill: time between SIGTERM and SIGKILL")
+		jobDir := fs.String("job-dir", envOr("SSH_JOB_DIR", sshkit.DefaultJobDir), "remote directory holding the jobs")
+		pos := parseInterspersed(fs, args[1:])
+		if len(pos) == 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		jobID := func() string {
+			if len(pos) != 2 {
+				fmt.Fprintf(os.Stderr, "usage: jobs %s <id>\n", pos[0])
+				os.Exit(2)
+			}
+			return pos[1]
+		}
+		client := connect()
+		client.JobDir = *jobDir
+		client.KillGrace = *grace
+		printJob := func(job *sshkit.Job, err error) {
+			if err != nil {
+				output.Fatalf("%v", err)
+			}
+			output.Print(job, func() { printJobs([]sshkit.Job{*job}) })
+		}
+
+		switch pos[0] {
+		case "start":
+			if *cmd == "" {
+				output.Fatalf("jobs start needs -cmd")
+			}
+			checkEnv(env)
+			c := sshkit.Script(*cmd).In(*dir)
+			c.Env = env
+			printJob(client.StartJob(c, *cmd))
+		case "list":
+			jobs, err := client.Jobs()
+			if err != nil {
+				output.Fatalf("%v", err)
+			}
+			if jobs == nil {
+				jobs = []sshkit.Job{}
+			}
+			output.Print(jobs, func() { printJobs(jobs) })
+		case "status":
+			printJob(client.JobStatus(jobID()))
+		case "kill":
+			printJob(client.KillJob(jobID()))
+		case "logs":
+			id := jobID()
+			err := client.JobLogs(context.Background(), id, *follow, os.Stdout)
+			var cmdErr *sshkit.CommandError
+			if errors.As(err, &cmdErr) {
+				// The job's own status.
+				client.Close()
+				os.Exit(cmdErr.ExitCode)
+			}
+			if err != nil {
+				output.Fatalf("%v", err)
+			}
+		default:
+			output.Fatalf("unknown jobs command %q (want start, list, status, logs or kill)", pos[0])
+		}
+
+	case "shell":
+		fs := flag.NewFlagSet("shell", flag.ExitOnError)
+		cmd := fs.String("cmd", "", "interactive program to run instead of a login shell (e.g. top)")
+		_ = fs.Parse(args[1:])
+		client := connect()
+		code, err := client.Shell(*cmd)
+		if err != nil {
+			output.Fatalf("shell: %v", err)
+		}
+		client.Close()
+		os.Exit(code)
+
 	case "shamir":
 		// read from environment first (Taskfile.yml passes them)
 		secretEnv := os.Getenv("SECRET")
@@ -87,7 +455,7 @@ func main() {
 		n := fs.Int("n", 5, "number of shares")
 		k := fs.Int("k", 3, "threshold")
 		dir := fs.String("dir", "/tmp/keys", "remote directory to store shares")
-		_ = fs.Parse(os.Args[2:])
+		_ = fs.Parse(args[1:])
 
 		// override with env if set
 		if secretEnv != "" {
@@ -107,53 +475,73 @@ func main() {
 			}
 		}
 
+		client := connect()
 		names, err := lib.CreateShamirShares(client, *secret, *n, *k, *dir)
 		if err != nil {
-			log.Fatalf("create shares failed: %v", err)
-		}
-		fmt.Println("✅ created shares:")
-		for _, n := range names {
-			fmt.Println(" -", n)
+			output.Fatalf("create shares failed: %v", err)
 		}
-
+		output.Print(map[string]any{"dir": *dir, "shares": names}, func() {
+			fmt.Println("✅ created shares:")
+			for _, n := range names {
+				fmt.Println(" -", n)
+			}
+		})
 
 	case "listkeys":
 		fs := flag.NewFlagSet("listkeys", flag.ExitOnError)
 		dir := fs.String("dir", "/tmp/keys", "remote directory containing key_*.json")
-		_ = fs.Parse(os.Args[2:])
+		_ = fs.Parse(args[1:])
+		client := connect()
 		names, err := lib.ListRemoteKeys(client, *dir)
 		if err != nil {
-			log.Fatalf("list keys failed: %v", err)
-		}
-		if len(names) == 0 {
-			fmt.Println("(no key_*.json found)")
-			return
+			output.Fatalf("list keys failed: %v", err)
 		}
-		fmt.Println("available key files:")
-		for _, n := range names {
-			fmt.Println(" -", n)
+		if names == nil {
+			names = []string{}
 		}
-
-	case "downloadkey":
-			fs := flag.NewFlagSet("downloadkey", flag.ExitOnError)
-			dir := fs.String("dir", "/tmp/keys", "remote directory containing key_*.json")
-			file := fs.String("file", "", "filename to download (e.g., key_03.json)")
-			out := fs.String("out", "", "local output path (default: same name in current dir)")
-			_ = fs.Parse(os.Args[2:])
-			if *file == "" {
-				fs.Usage()
-				os.Exit(2)
-			}
-			dest := *out
-			if strings.TrimSpace(dest) == "" {
-				dest = *file
+		output.Print(names, func() {
+			if len(names) == 0 {
+				fmt.Println("(no key_*.json found)")
+				return
 			}
-			if err := lib.DownloadRemoteKey(client, *dir, *file, dest); err != nil {
-				log.Fatalf("download failed: %v", err)
+			fmt.Println("available key files:")
+			for _, n := range names {
+				fmt.Println(" -", n)
 			}
+		})
+
+	case "downloadkey":
+		fs := flag.NewFlagSet("downloadkey", flag.ExitOnError)
+		dir := fs.String("dir", "/tmp/keys", "remote directory containing key_*.json")
+		file := fs.String("file", "", "filename to download (e.g., key_03.json)")
+		out := fs.String("out", "", "local output path (default: same name in current dir)")
+		_ = fs.Parse(args[1:])
+		if *file == "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+		dest := *out
+		if strings.TrimSpace(dest) == "" {
+			dest = *file
+		}
+		client := connect()
+		if err := lib.DownloadRemoteKey(client, *dir, *file, dest); err != nil {
+			output.Fatalf("download failed: %v", err)
+		}
+		output.Print(map[string]string{"file": *file, "dest": dest}, func() {
 			fmt.Printf("✅ downloaded %s -> %s\n", *file, dest)
+		})
 
 	case "monitor":
+		client := connect()
+		if output.JSON {
+			stats, err := lib.CollectStats(client)
+			if err != nil {
+				output.Fatalf("monitor failed: %v", err)
+			}
+			output.Print(stats, nil)
+			break
+		}
 		s, err := lib.CollectBasicStats(client)
 		if err != nil {
 			log.Fatalf("monitor failed: %v", err)
@@ -161,61 +549,270 @@ func main() {
 		fmt.Println(s)
 
 	case "automate":
-		if err := lib.Automate(client); err != nil {
+		fs := flag.NewFlagSet("automate", flag.ExitOnError)
+		inventory := fs.String("inventory", os.Getenv("SSH_INVENTORY"), "inventory file (default $SSH_INVENTORY)")
+		hosts := fs.String("hosts", "", "run on the inventory hosts matching this pattern instead of SSH_HOST")
+		batch := fs.String("batch", "1", "with -hosts, hosts per batch: a count or a percentage (20%)")
+		maxFail := fs.Int("max-fail", 0, "with -hosts, stop once more than this many hosts have failed (-1: never)")
+		confirm := fs.Bool("confirm", false, "with -hosts, ask before each batch after the first")
+		_ = fs.Parse(args[1:])
+		if *hosts != "" {
+			selected := selectHosts(*inventory, *hosts)
+			rollout := &sshkit.Rollout{Batch: *batch, MaxFail: *maxFail, Confirm: *confirm}
+			var w io.Writer = os.Stdout
+			if output.JSON {
+				w = os.Stderr
+			}
+			results, steps, err := lib.AutomateAll(dialer, selected, rollout, w)
+			if err != nil && results == nil {
+				output.Fatalf("automation failed: %v", err)
+			}
+			type hostResult struct {
+				Host   string              `json:"host"`
+				Status string              `json:"status"`
+				Error  string              `json:"error,omitempty"`
+				Steps  []lib.CommandResult `json:"steps"`
+			}
+			report := make([]hostResult, len(results))
+			failed := false
+			for i, r := range results {
+				report[i] = hostResult{Host: r.Host, Status: r.Status(), Steps: steps[i]}
+				if report[i].Steps == nil {
+					report[i].Steps = []lib.CommandResult{}
+				}
+				if r.Err != nil {
+					report[i].Error = r.Err.Error()
+					failed = true
+				}
+			}
+			output.Print(report, func() { sshkit.WriteRolloutSummary(os.Stdout, results) })
+			if err != nil {
+				if output.JSON {
+					os.Exit(1)
+				}
+				log.Fatalf("automation failed: %v", err)
+			}
+			if failed {
+				os.Exit(1)
+			}
+			if !output.JSON {
+				fmt.Println("✅ automation done")
+			}
+			break
+		}
+		client := connect()
+		var w io.Writer = os.Stdout
+		if output.JSON {
+			w = io.Discard
+		}
+		steps, err := lib.AutomateSteps(client, os.Getenv("SSH_HOST"), w)
+		if output.JSON {
+			output.Print(map[string]any{"ok": err == nil, "steps": steps}, nil)
+		}
+		if err != nil {
+			if output.JSON {
+				client.Close()
+				os.Exit(1)
+			}
 			log.Fatalf("automation failed: %v", err)
 		}
-		fmt.Println("✅ automation done")
+		if !output.JSON {
+			fmt.Println("✅ automation done")
+		}
 
 	case "log":
 		fs := flag.NewFlagSet("log", flag.ExitOnError)
 		msg := fs.String("msg", "", "message to log remotely")
 		path := fs.String("file", "/tmp/ssh_demo.log", "remote log file path")
-		_ = fs.Parse(os.Args[2:])
+		_ = fs.Parse(args[1:])
 		if *msg == "" {
 			fs.Usage()
 			os.Exit(2)
 		}
+		client := connect()
 		if err := lib.WriteRemoteLog(client, *path, *msg); err != nil {
-			log.Fatalf("remote log write failed: %v", err)
+			output.Fatalf("remote log write failed: %v", err)
 		}
-		fmt.Printf("✅ wrote to %s\n", *path)
+		output.Print(map[string]string{"file": *path, "message": *msg}, func() {
+			fmt.Printf("✅ wrote to %s\n", *path)
+		})
 
 	default:
 		usage()
 	}
 }
 
+// sshHostTarget resolves SSH_HOST, the target of single-host commands.
+func sshHostTarget() *sshkit.Target {
+	sshHost := os.Getenv("SSH_HOST")
+	if sshHost == "" {
+		output.Fatalf("SSH_HOST must be set")
+	}
+	target, err := sshkit.Resolve(sshHost)
+	if err != nil {
+		output.Fatalf("resolve %s: %v", sshHost, err)
+	}
+	return target
+}
+
+// selectHosts loads the inventory and returns the hosts matching pattern.
+func selectHosts(inventory, pattern string) []*sshkit.Host {
+	inv, err := sshkit.LoadInventory(inventory)
+	if err != nil {
+		output.Fatalf("%v", err)
+	}
+	hosts, err := inv.Select(pattern)
+	if err != nil {
+		output.Fatalf("%v", err)
+	}
+	return hosts
+}
+
+// execInput opens what exec -input names: nil for none, os.Stdin or a
+// file.
+func execInput(spec string) (io.Reader, error) {
+	switch spec {
+	case "", "none":
+		return nil, nil
+	case "-":
+		return os.Stdin, nil
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, fmt.Errorf("input: %w", err)
+	}
+	return f, nil
+}
+
+// checkEnv exits unless every -env value is KEY=value.
+func checkEnv(env []string) {
+	for _, kv := range env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			output.Fatalf("-env %q: want KEY=value", kv)
+		}
+	}
+}
+
+// parseInterspersed parses fs from args, allowing flags after positional
+// arguments (jobs logs <id> -follow), and returns the positional ones.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var pos []string
+	for {
+		_ = fs.Parse(args)
+		if fs.NArg() == 0 {
+			return pos
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// printJobs prints jobs as a table.
+func printJobs(jobs []sshkit.Job) {
+	for _, j := range jobs {
+		state := string(j.State)
+		if j.State == sshkit.JobExited {
+			state = fmt.Sprintf("exit=%d", j.Exit)
+		}
+		fmt.Printf("%-26s %-8s pid=%-7d %s  %s\n", j.ID, state, j.PID, j.Started.Format("2006-01-02 15:04:05"), j.Cmd)
+	}
+}
+
+// chunkFlags adds -chunk and -channels to fs; the returned function sets
+// them on a client once fs is parsed.
+func chunkFlags(fs *flag.FlagSet) func(*sshkit.Client) {
+	chunk := fs.String("chunk", "", "chunk size for files sent over several channels (default 4MiB)")
+	channels := fs.Int("channels", 0, "SFTP channels a large file is sent over (default 4, 1: single stream)")
+	return func(client *sshkit.Client) {
+		if *chunk != "" {
+			n, err := sshkit.ParseSize(*chunk)
+			if err != nil || n == 0 {
+				output.Fatalf("-chunk %q: want a byte count such as 4MiB", *chunk)
+			}
+			client.ChunkSize = n
+		}
+		if *channels < 0 {
+			output.Fatalf("-channels %d: want 1 or more", *channels)
+		}
+		client.Channels = *channels
+	}
+}
+
+// listFlag is a flag that can be given more than once.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
 func usage() {
-	fmt.Println(`usage:
-  go run main.go <command> [flags]
+	fmt.Print(`usage:
+  go run main.go [-output text|json] <command> [flags]
 
 commands:
-  upload       -local <path> -remote <path>
-  exec         -cmd "<remote command>"
+  upload       -local <file|dir|glob> [-local ...] -remote <path>
+  download     -remote <file|dir|glob> [-remote ...] [-local <path>]
+               directories are copied recursively, keeping modes and mtimes;
+               large files go in chunks over several channels [-chunk 4MiB] [-channels 4]
+  sync         -local <dir> -remote <dir> [-checksum] [-delete] [-dry-run]
+               send what changed (size and mtime, or SHA-256) as one tar stream into tar -x
+  bench        [-size 64MiB] [-dir /tmp] [-chunk 4MiB] [-channels 4]
+               time a single SFTP stream against chunked transfers, each way
+  exec         -cmd "<remote command>" [-timeout 30s] [-grace 5s] [-stream [-tail bytes]]
+               [-env KEY=value ...] [-dir <remote dir>] [-input <file>|-|none]
+               [-hosts 'web:&prod:!web03' [-inventory file] [-forks 10] [-interleave] [-template]]
+               local stdin is read only with -input -, so exec in a read loop keeps its input
+  script       -file <local script> [-interpreter auto|sh|bash|python3|...] [exec flags] [-- args...]
+               stream the script to the remote interpreter's stdin; nothing is uploaded
+  jobs         start -cmd "<remote command>" [-env KEY=value ...] [-dir <remote dir>]
+               | list | status <id> | logs <id> [-follow] | kill <id> [-grace 5s]
+               detached jobs that outlive the connection [-job-dir ~/.sshkit/jobs]
+  shell        [-cmd "<interactive program>"]
   shamir       [-secret <s>] [-n 5] [-k 3] [-dir /tmp/keys]
   listkeys     [-dir /tmp/keys]
   downloadkey  -file key_XX.json [-dir /tmp/keys] [-out <local>]
   monitor
-  automate
+  automate     [-hosts <pattern> [-inventory file] [-batch 1|20%] [-max-fail 0] [-confirm]]
+               the demo workflow; a host stops at its first command that exits non-zero
   log          -msg "<text>" [-file /tmp/ssh_demo.log]
-`)
-}
+  close        stop the shared connection started by SSH_CONTROL_PERSIST
 
-// parseUserHost splits "user@host" into user and host
-func parseUserHost(s string) (string, string) {
-	for i := 0; i < len(s); i++ {
-		if s[i] == '@' {
-			return s[:i], s[i+1:]
-		}
-	}
-	log.Fatalf("Invalid SSH_HOST format: %s", s)
-	return "", ""
+environment:
+  SSH_HOST             [user@]host[:port] or a ~/.ssh/config alias
+  SSH_INVENTORY        inventory file (INI or YAML) for exec -hosts
+  SSH_CONFIG           ssh config file (default ~/.ssh/config)
+  SSH_JUMP             jump hosts [user@]host[:port],... (overrides ProxyJump)
+  SSH_JUMP_KEY         private key path(s) for the jump hosts, SSH_JUMP or ProxyJump (instead of SSH_KEY)
+  SSH_AUTH_ORDER       agent,key,keyboard-interactive,password (default order)
+  SSH_KEY              private key path(s) (default ~/.ssh/id_ed25519, id_ecdsa, id_rsa)
+  SSH_KEY_PASSPHRASE   passphrase for SSH_KEY (prompted when unset)
+  SSH_PASS             password for password / keyboard-interactive auth
+  SSH_AUTH_SOCK        ssh-agent socket
+  SSH_HOST_KEY_CHECK   tofu (default), strict or off
+  SSH_KEEPALIVE        keepalive interval (default 30s, off to disable)
+  SSH_RECONNECT        redials of a dropped connection before the next command (default 3, 0: none)
+  SSH_KNOWN_HOSTS      project known_hosts file (checked before ~/.ssh/known_hosts)
+  SSH_CONTROL_PERSIST  share one connection across runs via a background broker,
+                       closed after this long idle (10m, 600) or on close ("yes": never)
+  SSH_JOB_DIR          remote directory for jobs (default .sshkit/jobs in the home directory)
+  SSH_DEMO_OUTPUT      default for -output: text, or json for one JSON document on
+                       stdout per command (errors as {"error": ...}, exit status non-zero)
+`)
 }
 
-
 /*
 go mod init sshdemo
 go mod tidy
 go run main.go
 
-*/
\ No newline at end of file
+*/